@@ -0,0 +1,240 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+)
+
+var InvalidFilePathErr = ecode.New(400, "INVALID_FILE_PATH", "invalid file path")
+
+type FileOptions struct {
+	Inline      bool      // true 浏览器内联展示，false 作为附件下载
+	ContentType string    // 为空时按文件名扩展名推断，推断失败时按内容嗅探
+	ModTime     time.Time // Last-Modified，用于 If-Modified-Since
+	ETag        string    // 为空时自动生成，用于 If-None-Match、If-Range
+}
+
+// File 以附件形式下载文件，filePath 不能直接使用用户输入，用户输入请使用 FileFromDir
+func File(c *gin.Context, filePath, fileName string) {
+	FileWith(c, filePath, fileName, nil)
+}
+
+// FileWith 下载文件，支持 Range、If-Modified-Since、If-None-Match
+func FileWith(c *gin.Context, filePath, fileName string, opt *FileOptions) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		fileError(c, fs.ErrNotExist)
+		return
+	}
+	if fileName == "" {
+		fileName = fi.Name()
+	}
+	serveFile(c, f, fi, fileName, opt)
+}
+
+// FileFromDir 下载 root 目录下的文件，name 可以来自用户输入，越出 root 的路径返回 InvalidFilePathErr
+func FileFromDir(c *gin.Context, root, name, fileName string, opt *FileOptions) {
+	FileFromFS(c, os.DirFS(root), name, fileName, opt)
+}
+
+// FileFromFS 下载 fsys 中的文件，name 可以来自用户输入，越出 fsys 的路径返回 InvalidFilePathErr
+func FileFromFS(c *gin.Context, fsys fs.FS, name, fileName string, opt *FileOptions) {
+	name, err := CleanFilePath(name)
+	if err != nil {
+		JSON(c, nil, err)
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		fileError(c, fs.ErrNotExist)
+		return
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		// fs.File 不支持 Seek 时读入内存
+		bs, err := io.ReadAll(f)
+		if err != nil {
+			fileError(c, err)
+			return
+		}
+		rs = bytes.NewReader(bs)
+	}
+	if fileName == "" {
+		fileName = fi.Name()
+	}
+	serveFile(c, rs, fi, fileName, opt)
+}
+
+// FileFromReader 下载 content 中的内容，未设置 opt.ETag 时不做 If-None-Match 校验
+func FileFromReader(c *gin.Context, content io.ReadSeeker, fileName string, opt *FileOptions) {
+	if opt == nil {
+		opt = &FileOptions{}
+	}
+	setFileHeader(c, content, fileName, opt)
+	http.ServeContent(c.Writer, c.Request, fileName, opt.ModTime, content)
+}
+
+// FileFromBytes 下载 data，未设置 opt.ETag 时使用内容 sha256 作为 ETag
+func FileFromBytes(c *gin.Context, data []byte, fileName string, opt *FileOptions) {
+	o := FileOptions{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.ETag == "" {
+		sum := sha256.Sum256(data)
+		o.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	FileFromReader(c, bytes.NewReader(data), fileName, &o)
+}
+
+// CleanFilePath 清理用户输入的相对路径，包含 .. 越级、绝对路径或非法字符时返回 InvalidFilePathErr
+func CleanFilePath(name string) (string, error) {
+	name = strings.TrimLeft(filepath.ToSlash(name), "/")
+	if name == "" || strings.Contains(name, "\x00") {
+		return "", InvalidFilePathErr
+	}
+	name = path.Clean(name)
+	if !fs.ValidPath(name) || name == "." {
+		return "", InvalidFilePathErr
+	}
+	return name, nil
+}
+
+// ContentDisposition 按 RFC 6266/5987 生成 Content-Disposition，非 ASCII 文件名使用 filename* 编码
+func ContentDisposition(inline bool, fileName string) string {
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	if fileName == "" {
+		return disposition
+	}
+	fallback, isASCII := asciiFileName(fileName)
+	if isASCII {
+		return fmt.Sprintf(`%s; filename="%s"`, disposition, fallback)
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, encodeRFC5987(fileName))
+}
+
+// DetectContentType 按文件名扩展名推断 Content-Type，推断失败时读取前 512 字节嗅探，读取后 content 会 Seek 回起始位置
+func DetectContentType(fileName string, content io.ReadSeeker) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(fileName)); ctype != "" {
+		return ctype, nil
+	}
+	var buf [512]byte
+	n, err := io.ReadFull(content, buf[:])
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func serveFile(c *gin.Context, content io.ReadSeeker, fi fs.FileInfo, fileName string, opt *FileOptions) {
+	o := FileOptions{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.ModTime.IsZero() {
+		o.ModTime = fi.ModTime()
+	}
+	if o.ETag == "" {
+		o.ETag = fmt.Sprintf(`W/"%x-%x"`, fi.Size(), o.ModTime.UnixNano())
+	}
+	FileFromReader(c, content, fileName, &o)
+}
+
+func setFileHeader(c *gin.Context, content io.ReadSeeker, fileName string, opt *FileOptions) {
+	h := c.Writer.Header()
+	h.Set("Content-Disposition", ContentDisposition(opt.Inline, fileName))
+	h.Set("X-Content-Type-Options", "nosniff")
+	if opt.ETag != "" {
+		h.Set("ETag", opt.ETag)
+	}
+	ctype := opt.ContentType
+	if ctype == "" {
+		ctype, _ = DetectContentType(fileName, content)
+	}
+	if ctype == "" {
+		ctype = TypeOctetStream
+	}
+	h.Set("Content-Type", ctype)
+}
+
+func fileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		JSON(c, nil, ecode.NotFoundErr)
+	case errors.Is(err, fs.ErrPermission):
+		JSON(c, nil, ecode.ForbiddenErr)
+	case errors.Is(err, fs.ErrInvalid):
+		JSON(c, nil, InvalidFilePathErr)
+	default:
+		JSON(c, nil, ecode.ServerErr.WithCause(err))
+	}
+}
+
+// asciiFileName 生成 filename 参数的 ASCII 兜底值，非 ASCII、引号、反斜杠和控制字符替换为 _
+func asciiFileName(name string) (fallback string, isASCII bool) {
+	isASCII = true
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 0x80:
+			isASCII = false
+			b.WriteByte('_')
+		case r < 0x20 || r == 0x7f || r == '"' || r == '\\':
+			isASCII = false
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), isASCII
+}
+
+// encodeRFC5987 RFC 5987 attr-char 之外的字节做百分号编码
+func encodeRFC5987(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || strings.IndexByte("!#$&+-.^_`|~", ch) >= 0 {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[ch>>4])
+		b.WriteByte(hexDigits[ch&0x0f])
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		inline bool
+		name   string
+		want   string
+	}{
+		{false, "report.csv", `attachment; filename="report.csv"`},
+		{true, "a.png", `inline; filename="a.png"`},
+		{false, "账单 2024.csv", `attachment; filename="__ 2024.csv"; filename*=UTF-8''%E8%B4%A6%E5%8D%95%202024.csv`},
+		{false, "a\"\r\nX-Inject: 1", `attachment; filename="a___X-Inject: 1"; filename*=UTF-8''a%22%0D%0AX-Inject%3A%201`},
+	}
+	for _, tt := range tests {
+		if got := ContentDisposition(tt.inline, tt.name); got != tt.want {
+			t.Errorf("ContentDisposition(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFileFromFS(t *testing.T) {
+	fsys := fstest.MapFS{"bills/2024.txt": {Data: []byte("0123456789")}}
+	g := gin.New()
+	g.GET("/file", func(c *gin.Context) {
+		FileFromFS(c, fsys, c.Query("name"), "", nil)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/file?name=/bills/2024.txt", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Fatalf("Range got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/file?name=bills/2024.txt", nil)
+	g.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	w = httptest.NewRecorder()
+	req.Header.Set("If-None-Match", etag)
+	g.ServeHTTP(w, req)
+	if etag == "" || w.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match got %d, etag %q", w.Code, etag)
+	}
+
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file?name=../../etc/passwd", nil))
+	if w.Body.String() != `{"code":400,"message":"invalid file path"}` {
+		t.Fatalf("traversal got %s", w.Body.String())
+	}
+}
+
+func TestFileModTimeETag(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a"), ModTime: time.Unix(100, 0)}}
+	g := gin.New()
+	g.GET("/file", func(c *gin.Context) {
+		sec, _ := strconv.ParseInt(c.Query("mod"), 10, 64)
+		FileFromFS(c, fsys, "a.txt", "", &FileOptions{ModTime: time.Unix(sec, 0)})
+	})
+	get := func(mod string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/file?mod="+mod, nil))
+		return w
+	}
+	// ETag 与 Last-Modified 均使用 opt.ModTime
+	w1, w2 := get("200"), get("300")
+	if w1.Header().Get("Last-Modified") != time.Unix(200, 0).UTC().Format(http.TimeFormat) || w1.Header().Get("ETag") == w2.Header().Get("ETag") {
+		t.Fatalf("Last-Modified %q, ETag %q %q", w1.Header().Get("Last-Modified"), w1.Header().Get("ETag"), w2.Header().Get("ETag"))
+	}
+}
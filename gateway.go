@@ -0,0 +1,394 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/limiter"
+	"github.com/go-pay/web/middleware"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	GatewayNotFoundErr  = ecode.New(http.StatusNotFound, "ROUTE_NOT_FOUND", "route not found")
+	GatewayAggregateErr = ecode.New(http.StatusBadGateway, "AGGREGATE_ERROR", "aggregate upstream error")
+)
+
+type GatewayConfig struct {
+	Routes []*GatewayRoute `json:"routes" yaml:"routes" toml:"routes"`
+}
+
+type GatewayRoute struct {
+	Path        string                        `json:"path" yaml:"path" toml:"path"`                      // gin 路由规则，例如 /api/order/:id、/api/user/*path
+	Methods     []string                      `json:"methods" yaml:"methods" toml:"methods"`             // 为空匹配全部方法
+	Proxy       *middleware.ProxyConfig       `json:"proxy" yaml:"proxy" toml:"proxy"`                   // 转发配置，与 Aggregate 二选一
+	Aggregate   []*GatewayCall                `json:"aggregate" yaml:"aggregate" toml:"aggregate"`       // 并发调用多个 upstream，合并为一个响应
+	Middlewares []string                      `json:"middlewares" yaml:"middlewares" toml:"middlewares"` // 通过 Gateway.Register 注册的中间件名称，按顺序执行
+	CORS        *middleware.CORSConfig        `json:"cors" yaml:"cors" toml:"cors"`                      // 路由级 cors，nil 不开启
+	Limiter     *limiter.Config               `json:"limiter" yaml:"limiter" toml:"limiter"`             // 路由级限流，nil 不开启
+	Concurrency *middleware.ConcurrencyConfig `json:"concurrency" yaml:"concurrency" toml:"concurrency"` // 路由级最大并发，nil 不开启
+	Timeout     xtime.Duration                `json:"timeout" yaml:"timeout" toml:"timeout"`             // 路由级超时，0 不限制
+}
+
+type GatewayCall struct {
+	Name     string `json:"name" yaml:"name" toml:"name"`             // 聚合结果中的字段名
+	Method   string `json:"method" yaml:"method" toml:"method"`       // default GET
+	Target   string `json:"target" yaml:"target" toml:"target"`       // upstream 地址，例如 http://user-svc:8080
+	Path     string `json:"path" yaml:"path" toml:"path"`             // 支持 :param 引用路由参数，为空使用请求路径，请求 query 原样透传
+	Raw      bool   `json:"raw" yaml:"raw" toml:"raw"`                // upstream 响应不是 HttpRsp 结构，整体作为结果
+	Optional bool   `json:"optional" yaml:"optional" toml:"optional"` // 调用失败时结果为 null，不影响整体响应
+}
+
+// Gateway 声明式路由表，按路由转发或聚合 upstream，支持运行时重新加载
+// 使用：g.Gin.NoRoute(gw.Handler()) 或 g.Gin.Any("/api/*path", gw.Handler())
+type Gateway struct {
+	mu          sync.Mutex
+	middlewares map[string]gin.HandlerFunc
+	table       atomic.Pointer[gatewayTable]
+	cancel      context.CancelFunc
+}
+
+// gatewayTable 一次加载生成的路由表
+type gatewayTable struct {
+	engine  *gin.Engine
+	proxies []*middleware.ReverseProxy
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{middlewares: make(map[string]gin.HandlerFunc)}
+}
+
+// Register 注册可在路由 middlewares 中引用的中间件，例如鉴权，需在 Load 之前调用
+func (gw *Gateway) Register(name string, h gin.HandlerFunc) *Gateway {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.middlewares[name] = h
+	return gw
+}
+
+// Load 加载路由表，出错时保留当前路由表
+func (gw *Gateway) Load(conf *GatewayConfig) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	t, err := gw.build(conf)
+	if err != nil {
+		return err
+	}
+	if old := gw.table.Swap(t); old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// LoadFile 按扩展名解析 json、yaml、toml 路由表文件并加载
+func (gw *Gateway) LoadFile(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	conf := &GatewayConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(bs, conf)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, conf)
+	case ".toml":
+		err = toml.Unmarshal(bs, conf)
+	default:
+		return fmt.Errorf("unsupported gateway file(%s)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse gateway file(%s) error: %w", path, err)
+	}
+	return gw.Load(conf)
+}
+
+// WatchFile 加载路由表文件，并按 interval 检查文件变化后重新加载，重新加载失败时保留当前路由表
+func (gw *Gateway) WatchFile(path string, interval time.Duration) error {
+	if err := gw.LoadFile(path); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	stat, _ := os.Stat(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	gw.mu.Lock()
+	if gw.cancel != nil {
+		gw.cancel()
+	}
+	gw.cancel = cancel
+	gw.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			st, err := os.Stat(path)
+			if err != nil || (stat != nil && st.ModTime().Equal(stat.ModTime()) && st.Size() == stat.Size()) {
+				continue
+			}
+			stat = st
+			if err = gw.LoadFile(path); err != nil {
+				xlog.Errorf("gateway reload %s error: %v", path, err)
+				continue
+			}
+			xlog.Warnf("gateway reload %s success", path)
+		}
+	}()
+	return nil
+}
+
+// Close 停止文件监听，释放路由表创建的 upstream 节点池
+func (gw *Gateway) Close() {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if gw.cancel != nil {
+		gw.cancel()
+		gw.cancel = nil
+	}
+	if old := gw.table.Swap(nil); old != nil {
+		old.close()
+	}
+}
+
+func (gw *Gateway) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gw.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := gw.table.Load()
+	if t == nil {
+		t = emptyGatewayTable
+	}
+	t.engine.ServeHTTP(w, r)
+}
+
+var emptyGatewayTable = &gatewayTable{engine: newGatewayEngine()}
+
+func newGatewayEngine() *gin.Engine {
+	e := gin.New()
+	e.HandleMethodNotAllowed = false
+	e.NoRoute(func(c *gin.Context) {
+		JSON(c, nil, GatewayNotFoundErr)
+	})
+	return e
+}
+
+func (gw *Gateway) build(conf *GatewayConfig) (_ *gatewayTable, err error) {
+	if conf == nil {
+		return nil, errors.New("gateway config is nil")
+	}
+	t := &gatewayTable{engine: newGatewayEngine()}
+	defer func() {
+		// 路由冲突时 gin 会 panic
+		if r := recover(); r != nil {
+			err = fmt.Errorf("gateway route error: %v", r)
+		}
+		if err != nil {
+			t.close()
+		}
+	}()
+	for i, route := range conf.Routes {
+		if route == nil {
+			return nil, fmt.Errorf("gateway route[%d] is nil", i)
+		}
+		handlers, err := gw.routeHandlers(t, route)
+		if err != nil {
+			return nil, fmt.Errorf("gateway route[%d](%s) error: %w", i, route.Path, err)
+		}
+		if len(route.Methods) == 0 {
+			t.engine.Any(route.Path, handlers...)
+			continue
+		}
+		for _, m := range route.Methods {
+			t.engine.Handle(strings.ToUpper(m), route.Path, handlers...)
+		}
+	}
+	return t, nil
+}
+
+// routeHandlers 中间件顺序：cors、限流、并发限制、超时、注册的中间件、转发或聚合
+func (gw *Gateway) routeHandlers(t *gatewayTable, route *GatewayRoute) (handlers gin.HandlersChain, err error) {
+	if !strings.HasPrefix(route.Path, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	if (route.Proxy == nil) == (len(route.Aggregate) == 0) {
+		return nil, errors.New("one of proxy and aggregate must be set")
+	}
+	if route.CORS != nil {
+		handlers = append(handlers, middleware.CORSWithConfig(route.CORS))
+	}
+	if route.Limiter != nil && route.Limiter.Rate != 0 {
+		handlers = append(handlers, middleware.Limiter(route.Path, limiter.NewLimiter(route.Limiter)))
+	}
+	if route.Concurrency != nil && route.Concurrency.MaxInflight != 0 {
+		handlers = append(handlers, middleware.ConcurrencyLimit(route.Concurrency))
+	}
+	if route.Timeout > 0 {
+		handlers = append(handlers, middleware.TimeoutWithConfig(&middleware.TimeoutConfig{Timeout: route.Timeout, Propagate: true}))
+	}
+	for _, name := range route.Middlewares {
+		h, ok := gw.middlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("middleware(%s) not registered", name)
+		}
+		handlers = append(handlers, h)
+	}
+	if route.Proxy != nil {
+		p, err := middleware.NewReverseProxy(route.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.proxies = append(t.proxies, p)
+		return append(handlers, p.Handler()), nil
+	}
+	for _, call := range route.Aggregate {
+		if call == nil || call.Name == "" || call.Target == "" {
+			return nil, errors.New("aggregate call name and target must be set")
+		}
+	}
+	return append(handlers, aggregateHandler(route.Aggregate)), nil
+}
+
+func (t *gatewayTable) close() {
+	for _, p := range t.proxies {
+		p.Close()
+	}
+}
+
+// aggregateHandler 并发调用 upstream，输出 {"code":200,"data":{"<name>":...}}
+func aggregateHandler(calls []*GatewayCall) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var (
+			wg      sync.WaitGroup
+			results = make([]json.RawMessage, len(calls))
+			errs    = make([]error, len(calls))
+		)
+		for i, call := range calls {
+			wg.Add(1)
+			go func(i int, call *GatewayCall) {
+				defer wg.Done()
+				results[i], errs[i] = aggregateCall(c, call)
+			}(i, call)
+		}
+		wg.Wait()
+		data := make(map[string]json.RawMessage, len(calls))
+		for i, call := range calls {
+			if errs[i] != nil {
+				xlog.Errorf("gateway aggregate %s %s error: %v", call.Name, call.Target, errs[i])
+				if !call.Optional {
+					JSON(c, nil, aggregateError(errs[i]))
+					return
+				}
+				results[i] = nil
+			}
+			if results[i] == nil {
+				results[i] = json.RawMessage("null")
+			}
+			data[call.Name] = results[i]
+		}
+		JSON(c, data, nil)
+	}
+}
+
+func aggregateCall(c *gin.Context, call *GatewayCall) (json.RawMessage, error) {
+	method := strings.ToUpper(call.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	uri := c.Request.URL.Path
+	if call.Path != "" {
+		var err error
+		if uri, err = expandPathParams(call.Path, c.Params); err != nil {
+			return nil, err
+		}
+	}
+	if c.Request.URL.RawQuery != "" {
+		uri += "?" + c.Request.URL.RawQuery
+	}
+	// 并发调用不能共享请求体
+	req := c.Request.Clone(c.Request.Context())
+	req.Method, req.Body, req.ContentLength = method, http.NoBody, 0
+	cc := c.Copy()
+	cc.Request = req
+	decoder := middleware.EnvelopeDecoder
+	if call.Raw {
+		decoder = middleware.RawDecoder
+	}
+	return middleware.GinProxy[json.RawMessage](cc, strings.TrimRight(call.Target, "/"), uri, middleware.WithDecoder(decoder))
+}
+
+// expandPathParams 按完整参数名替换 :name，值经过 url.PathEscape，*path 等通配参数 path.Clean 后按段转义并保留 /，未匹配的 :name 原样保留
+// 值中包含 .、.. 路径段时返回 ecode.RequestErr，防止跳出配置的路径前缀
+func expandPathParams(tpl string, params gin.Params) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(tpl); {
+		if tpl[i] != ':' {
+			b.WriteByte(tpl[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(tpl) && isParamChar(tpl[j]) {
+			j++
+		}
+		name := tpl[i+1 : j]
+		v, ok := params.Get(name)
+		if !ok || name == "" {
+			b.WriteString(tpl[i:j])
+			i = j
+			continue
+		}
+		segs := []string{v}
+		if strings.HasPrefix(v, "/") {
+			segs = strings.Split(path.Clean(v)[1:], "/")
+		}
+		for k, seg := range segs {
+			if seg == "." || seg == ".." {
+				return "", ecode.RequestErr.WithCause(fmt.Errorf("invalid path param(%s): %s", name, v))
+			}
+			if k > 0 {
+				b.WriteByte('/')
+			}
+			b.WriteString(url.PathEscape(seg))
+		}
+		i = j
+	}
+	return b.String(), nil
+}
+
+func isParamChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// aggregateError 保留 upstream 的业务错误，网络错误统一为 GatewayAggregateErr
+func aggregateError(err error) error {
+	if e := new(ecode.Error); errors.As(err, &e) {
+		return e
+	}
+	return GatewayAggregateErr.WithCause(err)
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+)
+
+func TestGateway(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/7":
+			_, _ = w.Write([]byte(`{"code":200,"message":"success","data":{"id":7}}`))
+		case "/orders":
+			_, _ = w.Write([]byte(`[{"id":"o-1"}]`))
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = w.Write([]byte("path=" + r.URL.Path + " user=" + r.Header.Get("X-User")))
+		}
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "gateway.yaml")
+	writeFile := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(`
+routes:
+  - path: /api/user/*path
+    methods: [GET]
+    middlewares: [auth]
+    timeout: 1s
+    proxy:
+      target: ` + upstream.URL + `
+      strip_prefix: /api/user
+  - path: /api/profile/:id
+    aggregate:
+      - name: user
+        target: ` + upstream.URL + `
+        path: /users/:id
+      - name: orders
+        target: ` + upstream.URL + `
+        path: /orders
+        raw: true
+      - name: coupons
+        target: ` + upstream.URL + `
+        path: /down
+        optional: true
+`)
+
+	gw := NewGateway().Register("auth", func(c *gin.Context) {
+		c.Request.Header.Set("X-User", "u-1")
+	})
+	if err := gw.WatchFile(file, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+	g := gin.New()
+	g.NoRoute(gw.Handler())
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	get := func(path string) string {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var sb strings.Builder
+		buf := make([]byte, 1024)
+		for {
+			n, err := resp.Body.Read(buf)
+			sb.Write(buf[:n])
+			if err != nil {
+				break
+			}
+		}
+		return sb.String()
+	}
+
+	if got := get("/api/user/info"); got != "path=/info user=u-1" {
+		t.Fatalf("proxy route = %s", got)
+	}
+	var rsp struct {
+		Code int                        `json:"code"`
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(get("/api/profile/7")), &rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Code != ecode.Success.Code() || string(rsp.Data["user"]) != `{"id":7}` ||
+		string(rsp.Data["orders"]) != `[{"id":"o-1"}]` || string(rsp.Data["coupons"]) != "null" {
+		t.Fatalf("aggregate route = %+v", rsp)
+	}
+
+	// 引用未注册的中间件，重新加载失败，保留当前路由表
+	if err := gw.Load(&GatewayConfig{Routes: []*GatewayRoute{{Path: "/x", Middlewares: []string{"none"}, Proxy: nil}}}); err == nil {
+		t.Fatal("Load() with invalid route err = nil")
+	}
+	if got := get("/api/user/info"); got != "path=/info user=u-1" {
+		t.Fatalf("proxy route after failed load = %s", got)
+	}
+
+	writeFile(`
+routes:
+  - path: /v2/*path
+    proxy:
+      target: ` + upstream.URL + `
+`)
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.HasPrefix(get("/v2/ping"), "path=/v2/ping") {
+		if time.Now().After(deadline) {
+			t.Fatal("WatchFile() did not reload route table")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := get("/api/user/info"); !strings.Contains(got, GatewayNotFoundErr.Message()) {
+		t.Fatalf("removed route = %s", got)
+	}
+}
+
+func TestExpandPathParams(t *testing.T) {
+	params := gin.Params{{Key: "id", Value: "7"}, {Key: "id2", Value: "a/../b c"}, {Key: "path", Value: "/x/y z"}}
+	cases := map[string]string{
+		"/users/:id":          "/users/7",
+		"/users/:id2/:id":     "/users/a%2F..%2Fb%20c/7",
+		"/files/:path":        "/files/x/y%20z",
+		"/users/:idx/:":       "/users/:idx/:",
+		"/users/:id.json?a=1": "/users/7.json?a=1",
+	}
+	for path, want := range cases {
+		if got, err := expandPathParams(path, params); err != nil || got != want {
+			t.Errorf("expandPathParams(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+
+	// 通配参数 path.Clean 后不能跳出前缀，单个参数不能是 .、..
+	if got, err := expandPathParams("/files/:path", gin.Params{{Key: "path", Value: "/../internal/admin"}}); err != nil || got != "/files/internal/admin" {
+		t.Errorf("clean catch-all = %q, %v", got, err)
+	}
+	for _, v := range []string{"..", "."} {
+		if _, err := expandPathParams("/users/:id/orders", gin.Params{{Key: "id", Value: v}}); !ecode.RequestErr.Is(err) {
+			t.Errorf("param %q err = %v, want RequestErr", v, err)
+		}
+	}
+}
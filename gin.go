@@ -26,6 +26,9 @@ type GinEngine struct {
 	wg       sync.WaitGroup
 	addrPort string
 	hookMaps map[hookType][]func(c context.Context)
+	cors     *middleware.CORSConfig
+	ws       *wsHub
+	wh       *webhookHub
 }
 
 func InitGin(c *Config) *GinEngine {
@@ -33,7 +36,7 @@ func InitGin(c *Config) *GinEngine {
 		c = &Config{Addr: ":2233"}
 	}
 	g := gin.New()
-	engine := &GinEngine{Gin: g, wg: sync.WaitGroup{}, addrPort: c.Addr, hookMaps: make(map[hookType][]func(c context.Context))}
+	engine := &GinEngine{Gin: g, wg: sync.WaitGroup{}, addrPort: c.Addr, hookMaps: make(map[hookType][]func(c context.Context)), cors: c.CORS, ws: newWsHub(), wh: newWebhookHub()}
 
 	if c.ReadTimeout == 0 {
 		c.ReadTimeout = xtime.Duration(60 * time.Second)
@@ -49,9 +52,22 @@ func InitGin(c *Config) *GinEngine {
 		WriteTimeout: time.Duration(c.WriteTimeout),
 	}
 	g.Use(middleware.Logger(), middleware.Recovery())
+	if c.Decompress != nil {
+		// 在 Recovery 之后注册，解压异常可被恢复并记录；路由上的 AccessLog 读取解压后的请求体
+		g.Use(middleware.Decompress(c.Decompress))
+	}
+	if c.Secure != nil {
+		g.Use(middleware.SecureHeaders(c.Secure))
+	}
+	if c.CORS != nil {
+		g.Use(middleware.CORSWithConfig(c.CORS))
+	}
 	if c.Limiter != nil && c.Limiter.Rate != 0 {
 		g.Use(middleware.Limiter("", limiter.NewLimiter(c.Limiter)))
 	}
+	if c.Concurrency != nil && c.Concurrency.MaxInflight != 0 {
+		g.Use(middleware.ConcurrencyLimit(c.Concurrency))
+	}
 	if !c.Debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -143,4 +159,10 @@ func (g *GinEngine) Close() {
 		g.server.SetKeepAlivesEnabled(false)
 		_ = g.server.Shutdown(context.Background())
 	}
+	// websocket 连接已被 Hijack，不受 server.Shutdown 管理，需单独关闭
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	g.ws.shutdown(ctx)
+	// 等待异步 webhook 队列中的事件处理完成
+	g.wh.shutdown(ctx)
 }
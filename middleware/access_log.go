@@ -1,9 +1,13 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"log"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -88,7 +92,7 @@ func AccessLog(appName string) gin.HandlerFunc {
 		if err != nil {
 			return
 		}
-		writer := responseWriter{ResponseWriter: c.Writer, resBs: &bytes.Buffer{}}
+		writer := &responseWriter{ResponseWriter: c.Writer, resBs: &bytes.Buffer{}}
 		c.Writer = writer
 		defer func() {
 			if len(defaultHeaderKey) != 0 {
@@ -112,6 +116,10 @@ func AccessLog(appName string) gin.HandlerFunc {
 			rbs := writer.resBs.Bytes()
 			rsp := &CommonRsp{}
 			_ = json.Unmarshal(rbs, rsp)
+			statusCode := c.Writer.Status()
+			if writer.hijacked {
+				statusCode = http.StatusSwitchingProtocols
+			}
 
 			output := &OutputLog{
 				AppName:    appName,
@@ -127,7 +135,7 @@ func AccessLog(appName string) gin.HandlerFunc {
 				ResMsg:     rsp.Message,
 				ResBody:    marshalString(rsp),
 				Schema:     schema,
-				StatusCode: c.Writer.Status(),
+				StatusCode: statusCode,
 				Ts:         st.Unix(),
 			}
 			log.Printf("access_log: %s\n\n", marshalString(output))
@@ -164,13 +172,55 @@ func marshalBytes(v any) []byte {
 // 自定义一个结构体，实现 gin.ResponseWriter interface
 type responseWriter struct {
 	gin.ResponseWriter
-	resBs *bytes.Buffer
+	resBs    *bytes.Buffer
+	hijacked bool
 }
 
 // 重写 Write([]byte) (int, error) 方法
-func (w responseWriter) Write(b []byte) (int, error) {
-	// 向一个bytes.buffer中写一份数据来为获取body使用
-	w.resBs.Write(b)
+func (w *responseWriter) Write(b []byte) (int, error) {
+	// 向一个bytes.buffer中写一份数据来为获取body使用，流式响应、压缩后的响应不记录body
+	if w.capture() {
+		w.resBs.Write(b)
+	}
 	// 完成gin.Context.Writer.Write()原有功能
 	return w.ResponseWriter.Write(b)
 }
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	if w.capture() {
+		w.resBs.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// recordBody Compress 压缩前写入未压缩的响应体
+func (w *responseWriter) recordBody(b []byte) {
+	if !isStreamResponse(w.Header()) {
+		w.resBs.Write(b)
+	}
+}
+
+func (w *responseWriter) capture() bool {
+	h := w.Header()
+	return !isStreamResponse(h) && h.Get("Content-Encoding") == ""
+}
+
+// Hijack websocket 等协议升级后连接交由调用方管理，不再记录body
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap 供 http.ResponseController 获取底层 ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// 长连接流式响应，body 无上限，不做记录
+func isStreamResponse(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	return strings.HasPrefix(ct, "text/event-stream") || strings.HasPrefix(ct, "application/x-ndjson")
+}
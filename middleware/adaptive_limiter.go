@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+)
+
+// Priority 请求优先级，过载时按 Low、Normal、High、Critical 的顺序丢弃
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+var (
+	OverloadedErr = ecode.New(http.StatusServiceUnavailable, "SERVER_OVERLOADED", "server overloaded, please retry later")
+
+	// 各优先级可使用的并发上限比例，低优先级先达到上限
+	priorityFactors = [...]float64{PriorityLow: 0.8, PriorityNormal: 1, PriorityHigh: 1.25, PriorityCritical: 1.5}
+	priorityNames   = map[string]Priority{"low": PriorityLow, "normal": PriorityNormal, "high": PriorityHigh, "critical": PriorityCritical}
+
+	cpuOnce  sync.Once
+	cpuUsage atomic.Uint64 // math.Float64bits
+)
+
+// ParsePriority 解析 low、normal、high、critical 或 0-3，无法解析时返回 PriorityNormal
+func ParsePriority(s string) Priority {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if p, ok := priorityNames[s]; ok {
+		return p
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= int(PriorityLow) && n <= int(PriorityCritical) {
+		return Priority(n)
+	}
+	return PriorityNormal
+}
+
+type AdaptiveLimiterConfig struct {
+	Window         xtime.Duration    `json:"window" yaml:"window" toml:"window"`                            // 统计窗口，default 10s
+	Buckets        int               `json:"buckets" yaml:"buckets" toml:"buckets"`                         // 窗口分桶数，default 100
+	CPUThreshold   float64           `json:"cpu_threshold" yaml:"cpu_threshold" toml:"cpu_threshold"`       // CPU 使用率超过该值时开始限流，取值 0-1，default 0.8
+	MinLimit       int               `json:"min_limit" yaml:"min_limit" toml:"min_limit"`                   // 并发上限的下限，default 10
+	RetryAfter     xtime.Duration    `json:"retry_after" yaml:"retry_after" toml:"retry_after"`             // 丢弃时的 Retry-After，default 1s
+	PriorityHeader string            `json:"priority_header" yaml:"priority_header" toml:"priority_header"` // 从请求头读取优先级，为空不读取，仅在内部调用方可信时开启
+	Routes         map[string]string `json:"routes" yaml:"routes" toml:"routes"`                            // 路由前缀 -> 优先级，按 c.FullPath() 最长前缀匹配，例如 /notify/: critical
+
+	CPU func() float64 `json:"-" yaml:"-" toml:"-"` // CPU 使用率，default 采样 /proc/stat，非 linux 平台不可用时不限流
+}
+
+// AdaptiveLimiterStats 当前限流状态，可用于指标上报
+type AdaptiveLimiterStats struct {
+	CPU      float64 `json:"cpu"`       // CPU 使用率
+	Limit    int64   `json:"limit"`     // 当前并发上限（PriorityNormal）
+	Inflight int64   `json:"inflight"`  // 处理中的请求数
+	MaxPass  int64   `json:"max_pass"`  // 窗口内单个桶最大完成请求数
+	MinRT    float64 `json:"min_rt_ms"` // 窗口内最小平均耗时，毫秒
+	Passed   int64   `json:"passed"`    // 累计通过的请求数
+	Dropped  int64   `json:"dropped"`   // 累计丢弃的请求数
+}
+
+// AdaptiveLimiter 参考 BBR 的自适应并发限流：CPU 过载时，并发上限为窗口内的最大吞吐 × 最小耗时
+type AdaptiveLimiter struct {
+	conf       *AdaptiveLimiterConfig
+	bucketDur  time.Duration
+	threshold  float64
+	minLimit   int64
+	retryAfter string
+	cpu        func() float64
+	routes     []routePriority
+
+	mu      sync.Mutex
+	buckets []rtBucket
+	cache   rtCache
+
+	inflight atomic.Int64
+	prevDrop atomic.Int64 // 上次丢弃的时间，unix nano
+	passed   atomic.Int64
+	dropped  atomic.Int64
+}
+
+type routePriority struct {
+	prefix   string
+	priority Priority
+}
+
+type rtBucket struct {
+	idx   int64
+	pass  int64
+	rtSum int64 // 微秒
+}
+
+// rtCache 窗口统计在当前桶内不变，每个桶只计算一次
+type rtCache struct {
+	idx     int64
+	maxPass int64
+	minRT   float64 // 微秒
+}
+
+func NewAdaptiveLimiter(conf *AdaptiveLimiterConfig) *AdaptiveLimiter {
+	if conf == nil {
+		conf = &AdaptiveLimiterConfig{}
+	}
+	n := intOr(conf.Buckets, 100)
+	l := &AdaptiveLimiter{
+		conf:       conf,
+		bucketDur:  durationOr(conf.Window, 10*time.Second) / time.Duration(n),
+		threshold:  conf.CPUThreshold,
+		minLimit:   int64(intOr(conf.MinLimit, 10)),
+		retryAfter: strconv.Itoa(int(math.Ceil(durationOr(conf.RetryAfter, time.Second).Seconds()))),
+		cpu:        conf.CPU,
+		buckets:    make([]rtBucket, n),
+		cache:      rtCache{idx: -1},
+	}
+	if l.threshold <= 0 {
+		l.threshold = 0.8
+	}
+	if l.cpu == nil {
+		l.cpu = systemCPU
+	}
+	for prefix, p := range conf.Routes {
+		l.routes = append(l.routes, routePriority{prefix: prefix, priority: ParsePriority(p)})
+	}
+	return l
+}
+
+// AdaptiveLimit gin middleware，CPU 过载且并发超过上限时丢弃请求，返回 503 和 Retry-After
+func AdaptiveLimit(conf *AdaptiveLimiterConfig) gin.HandlerFunc {
+	return NewAdaptiveLimiter(conf).Handler()
+}
+
+func (l *AdaptiveLimiter) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done, ok := l.Allow(l.priority(c))
+		if !ok {
+			c.Header("Retry-After", l.retryAfter)
+			writeErrorStatus(c.Writer, http.StatusServiceUnavailable, OverloadedErr)
+			c.Abort()
+			return
+		}
+		defer done()
+		c.Next()
+	}
+}
+
+// Allow 非 gin 场景使用，通过时需在请求完成后调用 done，超出范围的 p 按最近的优先级处理
+func (l *AdaptiveLimiter) Allow(p Priority) (done func(), ok bool) {
+	if l.shouldDrop(p) {
+		l.dropped.Add(1)
+		return nil, false
+	}
+	l.inflight.Add(1)
+	l.passed.Add(1)
+	start := time.Now()
+	return func() {
+		l.inflight.Add(-1)
+		l.record(time.Since(start))
+	}, true
+}
+
+func (l *AdaptiveLimiter) Stats() AdaptiveLimiterStats {
+	maxPass, minRT := l.window()
+	return AdaptiveLimiterStats{
+		CPU:      l.cpu(),
+		Limit:    l.limit(maxPass, minRT),
+		Inflight: l.inflight.Load(),
+		MaxPass:  maxPass,
+		MinRT:    minRT / 1000,
+		Passed:   l.passed.Load(),
+		Dropped:  l.dropped.Load(),
+	}
+}
+
+func (l *AdaptiveLimiter) priority(c *gin.Context) Priority {
+	if l.conf.PriorityHeader != "" {
+		if v := c.GetHeader(l.conf.PriorityHeader); v != "" {
+			return ParsePriority(v)
+		}
+	}
+	p, matched := PriorityNormal, -1
+	path := c.FullPath()
+	for _, r := range l.routes {
+		if len(r.prefix) > matched && strings.HasPrefix(path, r.prefix) {
+			p, matched = r.priority, len(r.prefix)
+		}
+	}
+	return p
+}
+
+// shouldDrop CPU 过载或距上次丢弃不足 1s 时，并发超过该优先级的上限则丢弃
+func (l *AdaptiveLimiter) shouldDrop(p Priority) bool {
+	p = min(max(p, PriorityLow), PriorityCritical)
+	maxPass, minRT := l.window()
+	limit := float64(l.limit(maxPass, minRT)) * priorityFactors[p]
+	inflight := float64(l.inflight.Load())
+	if l.cpu() < l.threshold {
+		prev := l.prevDrop.Load()
+		if prev == 0 {
+			return false
+		}
+		// 冷却期内维持限流，避免 CPU 刚回落时流量瞬间涌入
+		if time.Since(time.Unix(0, prev)) <= time.Second {
+			return inflight >= limit
+		}
+		l.prevDrop.CompareAndSwap(prev, 0)
+		return false
+	}
+	if inflight < limit {
+		return false
+	}
+	l.prevDrop.Store(time.Now().UnixNano())
+	return true
+}
+
+// limit 最大吞吐（每桶）× 最小耗时 / 桶时长
+func (l *AdaptiveLimiter) limit(maxPass int64, minRT float64) int64 {
+	n := int64(math.Ceil(float64(maxPass) * minRT / float64(l.bucketDur.Microseconds())))
+	if n < l.minLimit {
+		return l.minLimit
+	}
+	return n
+}
+
+func (l *AdaptiveLimiter) record(rt time.Duration) {
+	idx := time.Now().UnixNano() / int64(l.bucketDur)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := &l.buckets[idx%int64(len(l.buckets))]
+	if b.idx != idx {
+		*b = rtBucket{idx: idx}
+	}
+	b.pass++
+	b.rtSum += rt.Microseconds()
+}
+
+// window 窗口内（不含当前桶）的最大完成数和最小平均耗时（微秒）
+func (l *AdaptiveLimiter) window() (int64, float64) {
+	idx := time.Now().UnixNano() / int64(l.bucketDur)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cache.idx == idx {
+		return l.cache.maxPass, l.cache.minRT
+	}
+	var maxPass int64
+	minRT := math.MaxFloat64
+	for _, b := range l.buckets {
+		if b.idx >= idx || b.idx <= idx-int64(len(l.buckets)) || b.pass == 0 {
+			continue
+		}
+		maxPass = max(maxPass, b.pass)
+		minRT = min(minRT, float64(b.rtSum)/float64(b.pass))
+	}
+	if minRT == math.MaxFloat64 {
+		minRT = 0
+	}
+	l.cache = rtCache{idx: idx, maxPass: maxPass, minRT: minRT}
+	return maxPass, minRT
+}
+
+// systemCPU 每 500ms 采样一次 CPU 使用率并做指数平滑，容器设置了 CPU 配额时为配额的使用率
+func systemCPU() float64 {
+	cpuOnce.Do(func() {
+		read := cpuReader()
+		busy, total, err := read()
+		if err != nil {
+			xlog.Warnf("adaptive limiter: read cpu usage error: %v, cpu based limiting disabled", err)
+			return
+		}
+		go func() {
+			const decay = 0.8
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for range ticker.C {
+				b, t, err := read()
+				if err != nil || t <= total || b < busy {
+					continue
+				}
+				cur := min(float64(b-busy)/float64(t-total), 1)
+				busy, total = b, t
+				usage := math.Float64frombits(cpuUsage.Load())*decay + cur*(1-decay)
+				cpuUsage.Store(math.Float64bits(usage))
+			}
+		}()
+	})
+	return math.Float64frombits(cpuUsage.Load())
+}
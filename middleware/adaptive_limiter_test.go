@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestParsePriority(t *testing.T) {
+	cases := map[string]Priority{"low": PriorityLow, " Critical ": PriorityCritical, "2": PriorityHigh, "9": PriorityNormal, "": PriorityNormal}
+	for s, want := range cases {
+		if got := ParsePriority(s); got != want {
+			t.Errorf("ParsePriority(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	var cpu atomic.Value
+	cpu.Store(0.1)
+	l := NewAdaptiveLimiter(&AdaptiveLimiterConfig{
+		MinLimit:       2,
+		RetryAfter:     xtime.Duration(2 * time.Second),
+		PriorityHeader: "X-Priority",
+		Routes:         map[string]string{"/notify/": "critical"},
+		CPU:            func() float64 { return cpu.Load().(float64) },
+	})
+	release := make(chan struct{})
+	g := gin.New()
+	g.Use(l.Handler())
+	g.GET("/hold", func(c *gin.Context) {
+		<-release
+		c.String(http.StatusOK, "ok")
+	})
+	g.GET("/api", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	g.POST("/notify/:provider", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	do := func(method, path, priority string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		if priority != "" {
+			req.Header.Set("X-Priority", priority)
+		}
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	// CPU 未过载时不限流
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(http.MethodGet, "/hold", "")
+		}()
+	}
+	for l.Stats().Inflight != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if w := do(http.MethodGet, "/api", "low"); w.Code != http.StatusOK {
+		t.Fatalf("cpu idle: status = %d, want 200", w.Code)
+	}
+
+	// CPU 过载后低优先级先被丢弃，支付回调最后丢弃
+	cpu.Store(0.95)
+	w := do(http.MethodGet, "/api", "low")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("low: status = %d, retry-after = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := do(http.MethodGet, "/api", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("normal: status = %d, want 503", w.Code)
+	}
+	if w := do(http.MethodPost, "/notify/alipay", ""); w.Code != http.StatusOK {
+		t.Fatalf("route critical: status = %d, want 200", w.Code)
+	}
+	if w := do(http.MethodGet, "/api", "critical"); w.Code != http.StatusOK {
+		t.Fatalf("header critical: status = %d, want 200", w.Code)
+	}
+
+	// CPU 回落后冷却期内仍按上限丢弃
+	cpu.Store(0.1)
+	if w := do(http.MethodGet, "/api", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("cooldown: status = %d, want 503", w.Code)
+	}
+	close(release)
+	wg.Wait()
+	if w := do(http.MethodGet, "/api", ""); w.Code != http.StatusOK {
+		t.Fatalf("drained: status = %d, want 200", w.Code)
+	}
+
+	s := l.Stats()
+	if s.Inflight != 0 || s.Limit != 2 || s.Dropped != 3 || s.Passed != 6 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestAdaptiveLimiterWindow(t *testing.T) {
+	l := NewAdaptiveLimiter(&AdaptiveLimiterConfig{
+		Window:   xtime.Duration(time.Second),
+		Buckets:  10,
+		MinLimit: 1,
+		CPU:      func() float64 { return 0 },
+	})
+	// 上一个桶内完成 50 个请求，平均耗时 20ms：上限 = 50 × 20ms / 100ms = 10
+	idx := time.Now().UnixNano()/int64(l.bucketDur) - 1
+	l.buckets[idx%10] = rtBucket{idx: idx, pass: 50, rtSum: 50 * 20000}
+	if s := l.Stats(); s.MaxPass != 50 || s.MinRT != 20 || s.Limit != 10 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestAdaptiveLimiterPriorityRange(t *testing.T) {
+	l := NewAdaptiveLimiter(&AdaptiveLimiterConfig{MinLimit: 1, CPU: func() float64 { return 1 }})
+	done, ok := l.Allow(Priority(-1))
+	if !ok {
+		t.Fatal("first request should pass")
+	}
+	defer done()
+	// 超出范围的优先级按 PriorityCritical 处理，上限 1 × 1.5 允许第二个请求
+	done2, ok := l.Allow(Priority(99))
+	if !ok {
+		t.Fatal("out of range priority should be clamped to critical")
+	}
+	done2()
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xtime"
+)
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+const (
+	BreakerSuccess BreakerResult = iota
+	BreakerFailure
+	BreakerIgnore // 请求未得到 upstream 的结果，例如客户端取消，只释放半开探测名额
+)
+
+var BreakerOpenErr = ecode.New(http.StatusServiceUnavailable, "CIRCUIT_OPEN", "circuit breaker open")
+
+type BreakerState int
+
+// BreakerResult 放行请求的结果
+type BreakerResult int
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerConfig struct {
+	FailureThreshold int                                      `json:"failure_threshold" yaml:"failure_threshold" toml:"failure_threshold"` // 连续失败多少次熔断，default 5
+	OpenTimeout      xtime.Duration                           `json:"open_timeout" yaml:"open_timeout" toml:"open_timeout"`                // 熔断多久后进入半开，default 30s
+	HalfOpenProbes   int                                      `json:"half_open_probes" yaml:"half_open_probes" toml:"half_open_probes"`    // 半开时放行的探测请求数，全部成功后恢复，default 1
+	OnStateChange    func(name string, from, to BreakerState) `json:"-" yaml:"-" toml:"-"`                                                 // 状态变化回调，用于告警和监控
+}
+
+// BreakerGroup 按 upstream 名称（host）隔离的熔断器
+type BreakerGroup struct {
+	conf     *BreakerConfig
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	state    BreakerState
+	fails    int
+	openedAt time.Time
+	probes   int    // 半开状态已放行的探测数
+	passed   int    // 半开状态探测成功数
+	gen      uint64 // 状态变化次数，释放探测名额时忽略之前状态放行的请求
+}
+
+func NewBreakerGroup(conf *BreakerConfig) *BreakerGroup {
+	if conf == nil {
+		conf = &BreakerConfig{}
+	}
+	if conf.FailureThreshold <= 0 {
+		conf.FailureThreshold = 5
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = xtime.Duration(30 * time.Second)
+	}
+	if conf.HalfOpenProbes <= 0 {
+		conf.HalfOpenProbes = 1
+	}
+	return &BreakerGroup{conf: conf, breakers: make(map[string]*breaker)}
+}
+
+// Allow 熔断或半开探测数已满时返回 BreakerOpenErr，放行时必须调用 done 上报结果
+func (g *BreakerGroup) Allow(name string) (done func(result BreakerResult), err error) {
+	g.mu.Lock()
+	b := g.get(name)
+	var notify func()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= time.Duration(g.conf.OpenTimeout) {
+		notify = g.setState(name, b, BreakerHalfOpen)
+	}
+	state, gen := b.state, b.gen
+	if state == BreakerHalfOpen {
+		if b.probes >= g.conf.HalfOpenProbes {
+			state = BreakerOpen
+		} else {
+			b.probes++
+		}
+	}
+	g.mu.Unlock()
+	if notify != nil {
+		notify()
+	}
+	if state == BreakerOpen {
+		return nil, BreakerOpenErr
+	}
+	var once sync.Once
+	return func(result BreakerResult) {
+		once.Do(func() {
+			if result == BreakerIgnore {
+				g.release(name, gen)
+				return
+			}
+			g.report(name, result == BreakerSuccess)
+		})
+	}, nil
+}
+
+// State 当前熔断状态
+func (g *BreakerGroup) State(name string) BreakerState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.get(name).state
+}
+
+func (g *BreakerGroup) report(name string, success bool) {
+	g.mu.Lock()
+	b := g.get(name)
+	var notify func()
+	switch b.state {
+	case BreakerClosed:
+		if success {
+			b.fails = 0
+		} else if b.fails++; b.fails >= g.conf.FailureThreshold {
+			notify = g.setState(name, b, BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if !success {
+			notify = g.setState(name, b, BreakerOpen)
+		} else if b.passed++; b.passed >= g.conf.HalfOpenProbes {
+			notify = g.setState(name, b, BreakerClosed)
+		}
+	}
+	g.mu.Unlock()
+	if notify != nil {
+		notify()
+	}
+}
+
+// release 归还半开探测名额，不计入成功或失败
+func (g *BreakerGroup) release(name string, gen uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if b := g.get(name); b.state == BreakerHalfOpen && b.gen == gen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (g *BreakerGroup) get(name string) *breaker {
+	b, ok := g.breakers[name]
+	if !ok {
+		b = &breaker{}
+		g.breakers[name] = b
+	}
+	return b
+}
+
+// setState 返回的回调需在释放锁后调用，避免回调中调用 State 死锁
+func (g *BreakerGroup) setState(name string, b *breaker, to BreakerState) (notify func()) {
+	from := b.state
+	b.state, b.fails, b.probes, b.passed = to, 0, 0, 0
+	b.gen++
+	if to == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	if g.conf.OnStateChange == nil || from == to {
+		return nil
+	}
+	return func() { g.conf.OnStateChange(name, from, to) }
+}
@@ -0,0 +1,437 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+)
+
+const (
+	CacheHit   = "HIT"
+	CacheMiss  = "MISS"
+	CacheStale = "STALE"
+
+	// CacheStatusHeader 缓存命中状态响应头
+	CacheStatusHeader = "X-Cache"
+)
+
+type CacheConfig struct {
+	TTL                  xtime.Duration `json:"ttl" yaml:"ttl" toml:"ttl"`                                                          // 新鲜期，handler 返回 Cache-Control max-age 时以其为准，default 1m
+	StaleWhileRevalidate xtime.Duration `json:"stale_while_revalidate" yaml:"stale_while_revalidate" toml:"stale_while_revalidate"` // 过期后继续返回旧数据的时长，期间在后台刷新，0 关闭
+	QueryKeys            []string       `json:"query_keys" yaml:"query_keys" toml:"query_keys"`                                     // 参与缓存 key 的 query 参数，为空使用全部 query
+	Vary                 []string       `json:"vary" yaml:"vary" toml:"vary"`                                                       // 参与缓存 key 的请求头，例如 Accept-Language、X-Merchant-Id，响应 Vary 的请求头不在其中时不缓存
+	KeyPrefix            string         `json:"key_prefix" yaml:"key_prefix" toml:"key_prefix"`                                     // default web:cache:
+	MaxBodySize          int            `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size"`                            // 超过该大小的响应不缓存，default 1MB
+	Store                CacheStore     `json:"-" yaml:"-" toml:"-"`                                                                // default NewMemoryCacheStore(10000)
+}
+
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Stale       int64 `json:"stale"`
+	Uncacheable int64 `json:"uncacheable"` // 未命中且响应不可缓存
+	StoreErrors int64 `json:"store_errors"`
+}
+
+// ResponseCache GET、HEAD 响应缓存，并发未命中时只有一个请求执行 handler
+type ResponseCache struct {
+	conf      *CacheConfig
+	store     CacheStore
+	queryKeys []string
+
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+
+	hits, misses, stale, uncacheable, storeErrors atomic.Int64
+}
+
+// cacheCall 同一 key 正在执行的 handler
+type cacheCall struct {
+	done  chan struct{}
+	entry *CacheEntry // nil 表示响应不可缓存
+}
+
+func NewResponseCache(conf *CacheConfig) *ResponseCache {
+	if conf == nil {
+		conf = &CacheConfig{}
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = xtime.Duration(time.Minute)
+	}
+	if conf.KeyPrefix == "" {
+		conf.KeyPrefix = "web:cache:"
+	}
+	if conf.MaxBodySize <= 0 {
+		conf.MaxBodySize = 1 << 20
+	}
+	if conf.Store == nil {
+		conf.Store = NewMemoryCacheStore(0)
+	}
+	rc := &ResponseCache{conf: conf, store: conf.Store, calls: make(map[string]*cacheCall)}
+	rc.queryKeys = append(rc.queryKeys, conf.QueryKeys...)
+	sort.Strings(rc.queryKeys)
+	return rc
+}
+
+// Cache gin middleware response cache，按路由使用以配置不同 TTL
+func Cache(conf *CacheConfig) gin.HandlerFunc {
+	return NewResponseCache(conf).Handler()
+}
+
+func (rc *ResponseCache) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m := c.Request.Method; m != http.MethodGet && m != http.MethodHead {
+			c.Next()
+			return
+		}
+		key := rc.Key(c.Request)
+		ctx := c.Request.Context()
+		e, err := rc.store.Get(ctx, key)
+		if err != nil {
+			rc.storeErrors.Add(1)
+			xlog.Warnf("cache get %s error: %v", key, err)
+		}
+		if e != nil && time.Now().Before(e.ExpireAt) {
+			rc.hits.Add(1)
+			rc.serve(c, e, CacheHit)
+			c.Abort()
+			return
+		}
+		call, leader := rc.join(key)
+		if e != nil {
+			// stale-while-revalidate：返回旧数据，由第一个请求在后台刷新
+			rc.stale.Add(1)
+			rc.serve(c, e, CacheStale)
+			if leader {
+				rc.refresh(c, key, call)
+			}
+			c.Abort()
+			return
+		}
+		if !leader {
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				c.Abort()
+				return
+			}
+			if call.entry != nil {
+				rc.hits.Add(1)
+				rc.serve(c, call.entry, CacheHit)
+				c.Abort()
+				return
+			}
+			// 响应不可缓存（例如错误、私有数据），各自执行 handler
+			rc.misses.Add(1)
+			c.Header(CacheStatusHeader, CacheMiss)
+			c.Next()
+			return
+		}
+		rc.misses.Add(1)
+		c.Header(CacheStatusHeader, CacheMiss)
+		rc.fill(c, key, call, c.Next)
+	}
+}
+
+// Key 请求对应的缓存 key：method、host、path、选定的 query 和 Vary 请求头
+func (rc *ResponseCache) Key(r *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(r.Method + "\n" + r.Host + "\n" + r.URL.Path + "\n")
+	query := r.URL.Query()
+	if len(rc.queryKeys) > 0 {
+		selected := make(url.Values, len(rc.queryKeys))
+		for _, k := range rc.queryKeys {
+			if v, ok := query[k]; ok {
+				selected[k] = v
+			}
+		}
+		query = selected
+	}
+	// 转义后拼接，避免 ?a=1&b=2 与 ?a=1%26b%3D2 得到相同的 key
+	sb.WriteString(query.Encode())
+	for _, h := range rc.conf.Vary {
+		sb.WriteString("\n" + h + ":" + strings.Join(r.Header.Values(h), ","))
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return rc.conf.KeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// Purge 删除请求对应的缓存，例如数据更新后
+func (rc *ResponseCache) Purge(ctx context.Context, r *http.Request) error {
+	return rc.store.Delete(ctx, rc.Key(r))
+}
+
+func (rc *ResponseCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:        rc.hits.Load(),
+		Misses:      rc.misses.Load(),
+		Stale:       rc.stale.Load(),
+		Uncacheable: rc.uncacheable.Load(),
+		StoreErrors: rc.storeErrors.Load(),
+	}
+}
+
+func (rc *ResponseCache) join(key string) (call *cacheCall, leader bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if call, ok := rc.calls[key]; ok {
+		return call, false
+	}
+	call = &cacheCall{done: make(chan struct{})}
+	rc.calls[key] = call
+	return call, true
+}
+
+// refresh 在后台执行路由 handler 刷新缓存，不受客户端断开影响，同一 key 只有一个刷新
+// 请求结束后 gin.Context 会被复用，后台使用 c.Copy()，只执行路由 handler，不经过 Cache 之后注册的中间件
+func (rc *ResponseCache) refresh(c *gin.Context, key string, call *cacheCall) {
+	handler := c.Handler()
+	cc := c.Copy()
+	cc.Request = c.Request.WithContext(context.WithoutCancel(c.Request.Context()))
+	cc.Writer = &nopResponseWriter{header: make(http.Header), status: http.StatusOK, size: -1}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				xlog.Errorf("cache refresh %s panic: %v", key, r)
+			}
+		}()
+		rc.fill(cc, key, call, func() { handler(cc) })
+	}()
+}
+
+// fill 执行 handler 并缓存响应
+func (rc *ResponseCache) fill(c *gin.Context, key string, call *cacheCall, next func()) {
+	defer func() {
+		rc.mu.Lock()
+		delete(rc.calls, key)
+		rc.mu.Unlock()
+		close(call.done)
+	}()
+	origin := c.Writer
+	w := &cacheWriter{ResponseWriter: origin, limit: rc.conf.MaxBodySize}
+	c.Writer = w
+	defer func() { c.Writer = origin }()
+	next()
+
+	e, ttl := rc.entry(w)
+	if e == nil {
+		rc.uncacheable.Add(1)
+		return
+	}
+	call.entry = e
+	// 客户端断开不影响写入缓存
+	if err := rc.store.Set(context.WithoutCancel(c.Request.Context()), key, e, ttl); err != nil {
+		rc.storeErrors.Add(1)
+		xlog.Warnf("cache set %s error: %v", key, err)
+	}
+}
+
+// entry 按状态码、Cache-Control、业务 code 判断是否可缓存，返回缓存条目和存储时长
+func (rc *ResponseCache) entry(w *cacheWriter) (*CacheEntry, time.Duration) {
+	h := w.Header()
+	if w.Status() != http.StatusOK || w.overflow || h.Get("Set-Cookie") != "" || !rc.varyCovered(h) || isStreamResponse(h) {
+		return nil, 0
+	}
+	ttl, swr := time.Duration(rc.conf.TTL), time.Duration(rc.conf.StaleWhileRevalidate)
+	if cc := h.Get("Cache-Control"); cc != "" {
+		var ok bool
+		if ttl, swr, ok = parseCacheControl(cc, ttl, swr); !ok || ttl <= 0 {
+			return nil, 0
+		}
+	}
+	body := w.body.Bytes()
+	if strings.Contains(h.Get("Content-Type"), "json") {
+		// 统一响应结构的错误也是 HTTP 200，只缓存成功的响应
+		var rsp struct {
+			Code *int `json:"code"`
+		}
+		if json.Unmarshal(body, &rsp) == nil && rsp.Code != nil && *rsp.Code != ecode.Success.Code() {
+			return nil, 0
+		}
+	}
+	header := h.Clone()
+	for _, k := range append([]string{CacheStatusHeader, "Content-Length", "Age"}, hopHeaders...) {
+		header.Del(k)
+	}
+	now := time.Now()
+	return &CacheEntry{
+		Status:   http.StatusOK,
+		Header:   header,
+		Body:     append([]byte(nil), body...),
+		StoredAt: now,
+		ExpireAt: now.Add(ttl),
+	}, ttl + swr
+}
+
+// varyCovered 响应 Vary 的请求头都在 CacheConfig.Vary 中时才可缓存，例如 Compress 的 Accept-Encoding、CORS 的 Origin
+func (rc *ResponseCache) varyCovered(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if name == "*" || !slices.ContainsFunc(rc.conf.Vary, func(k string) bool { return strings.EqualFold(k, name) }) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseCacheControl no-store、no-cache、private 不缓存，s-maxage 优先于 max-age
+func parseCacheControl(cc string, ttl, swr time.Duration) (time.Duration, time.Duration, bool) {
+	var sMaxAge time.Duration = -1
+	for _, d := range strings.Split(cc, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		seconds := func() time.Duration {
+			n, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || n < 0 {
+				return 0
+			}
+			return time.Duration(n) * time.Second
+		}
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0, 0, false
+		case "max-age":
+			ttl = seconds()
+		case "s-maxage":
+			sMaxAge = seconds()
+		case "stale-while-revalidate":
+			swr = seconds()
+		}
+	}
+	if sMaxAge >= 0 {
+		ttl = sMaxAge
+	}
+	return ttl, swr, true
+}
+
+func (rc *ResponseCache) serve(c *gin.Context, e *CacheEntry, status string) {
+	h := c.Writer.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(CacheStatusHeader, status)
+	h.Set("Age", strconv.Itoa(int(time.Since(e.StoredAt).Seconds())))
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	c.Writer.WriteHeader(e.Status)
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	_, _ = c.Writer.Write(e.Body)
+}
+
+// cacheWriter 记录响应体，超过 limit 后不再记录
+type cacheWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *cacheWriter) capture(b []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(b) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
+
+// Unwrap 供 http.ResponseController 获取底层 ResponseWriter
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// nopResponseWriter 后台刷新缓存时使用，响应不写给客户端
+type nopResponseWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *nopResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *nopResponseWriter) WriteHeader(code int) {
+	if !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *nopResponseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *nopResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(b)
+	return len(b), nil
+}
+
+func (w *nopResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *nopResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *nopResponseWriter) Size() int {
+	return w.size
+}
+
+func (w *nopResponseWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *nopResponseWriter) Flush() {}
+
+func (w *nopResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("response cache refresh does not support hijack")
+}
+
+func (w *nopResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *nopResponseWriter) Pusher() http.Pusher {
+	return nil
+}
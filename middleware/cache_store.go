@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CacheStore 响应缓存存储
+type CacheStore interface {
+	// Get key 不存在或已过期时返回 nil, nil
+	Get(ctx context.Context, key string) (*CacheEntry, error)
+	// Set ttl 为存储时长，包含 stale-while-revalidate 时间
+	Set(ctx context.Context, key string, e *CacheEntry, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// CacheEntry 缓存的响应
+type CacheEntry struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+	ExpireAt time.Time   `json:"expire_at"` // 新鲜期截止时间，之后在 stale-while-revalidate 时间内返回旧数据
+}
+
+// MemoryCacheStore 进程内 LRU 缓存
+type MemoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key      string
+	entry    *CacheEntry
+	deadline time.Time
+}
+
+// NewMemoryCacheStore maxEntries 为最大缓存条数，超出时淘汰最久未使用的，default 10000
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{maxEntries: intOr(maxEntries, 10000), ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (s *MemoryCacheStore) Get(_ context.Context, key string) (*CacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryCacheItem)
+	if time.Now().After(item.deadline) {
+		s.remove(el)
+		return nil, nil
+	}
+	s.ll.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *MemoryCacheStore) Set(_ context.Context, key string, e *CacheEntry, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := &memoryCacheItem{key: key, entry: e, deadline: time.Now().Add(ttl)}
+	if el, ok := s.items[key]; ok {
+		el.Value = item
+		s.ll.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(item)
+	for s.ll.Len() > s.maxEntries {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+func (s *MemoryCacheStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Len 当前缓存条数
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+func (s *MemoryCacheStore) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.items, el.Value.(*memoryCacheItem).key)
+}
+
+// RedisCacheStore 基于 Redis 协议的缓存，多实例共享
+type RedisCacheStore struct {
+	cli *redisClient
+}
+
+func NewRedisCacheStore(conf *RedisConfig) (*RedisCacheStore, error) {
+	cli, err := newRedisClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisCacheStore{cli: cli}, nil
+}
+
+func (s *RedisCacheStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	reply, err := s.cli.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	str, _ := reply.(string)
+	e := &CacheEntry{}
+	if err = json.Unmarshal([]byte(str), e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *RedisCacheStore) Set(ctx context.Context, key string, e *CacheEntry, ttl time.Duration) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.cli.do(ctx, "SET", key, bs, "PX", redisTTL(ttl))
+	return err
+}
+
+func (s *RedisCacheStore) Delete(ctx context.Context, key string) error {
+	_, err := s.cli.do(ctx, "DEL", key)
+	return err
+}
+
+// Close 关闭空闲连接
+func (s *RedisCacheStore) Close() {
+	s.cli.close()
+}
@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	rc := NewResponseCache(&CacheConfig{
+		TTL:                  xtime.Duration(50 * time.Millisecond),
+		StaleWhileRevalidate: xtime.Duration(time.Second),
+		QueryKeys:            []string{"id"},
+		Vary:                 []string{"Accept-Language"},
+	})
+	g := gin.New()
+	g.Use(rc.Handler())
+	g.GET("/rate", func(c *gin.Context) {
+		n := calls.Add(1)
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 200, "data": n})
+	})
+	g.GET("/no_store", func(c *gin.Context) {
+		calls.Add(1)
+		c.Header("Cache-Control", "no-store")
+		c.String(http.StatusOK, "ok")
+	})
+	g.GET("/biz_error", func(c *gin.Context) {
+		calls.Add(1)
+		c.JSON(http.StatusOK, gin.H{"code": 500, "message": "db error"})
+	})
+	do := func(path, lang string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Language", lang)
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	// 并发未命中只执行一次 handler
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do("/rate?id=1", "zh")
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("singleflight handler calls = %d, want 1", calls.Load())
+	}
+	w := do("/rate?id=1&_t=123", "zh")
+	if w.Header().Get(CacheStatusHeader) != CacheHit || !strings.Contains(w.Body.String(), `"data":1`) {
+		t.Fatalf("hit = %s %s", w.Header().Get(CacheStatusHeader), w.Body.String())
+	}
+	if w = do("/rate?id=1", "en"); w.Header().Get(CacheStatusHeader) != CacheMiss {
+		t.Fatalf("vary header = %s, want MISS", w.Header().Get(CacheStatusHeader))
+	}
+
+	// 过期后返回旧数据并在后台刷新，客户端断开不影响刷新
+	time.Sleep(60 * time.Millisecond)
+	w = httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/rate?id=1", nil).WithContext(ctx)
+	r.Header.Set("Accept-Language", "zh")
+	g.ServeHTTP(w, r)
+	cancel()
+	if w.Header().Get(CacheStatusHeader) != CacheStale || !strings.Contains(w.Body.String(), `"data":1`) {
+		t.Fatalf("stale = %s %s", w.Header().Get(CacheStatusHeader), w.Body.String())
+	}
+	time.Sleep(50 * time.Millisecond)
+	if w = do("/rate?id=1", "zh"); w.Header().Get(CacheStatusHeader) != CacheHit || !strings.Contains(w.Body.String(), `"data":3`) {
+		t.Fatalf("revalidated = %s %s", w.Header().Get(CacheStatusHeader), w.Body.String())
+	}
+
+	for _, path := range []string{"/no_store", "/biz_error"} {
+		before := calls.Load()
+		do(path, "zh")
+		do(path, "zh")
+		if calls.Load()-before != 2 {
+			t.Fatalf("%s cached, handler calls = %d", path, calls.Load()-before)
+		}
+	}
+	if st := rc.Stats(); st.Hits < 10 || st.Stale != 1 || st.Uncacheable != 4 {
+		t.Fatalf("Stats() = %+v", st)
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	body := strings.Repeat(`{"code":200,"data":"compressible"}`, 100)
+	newEngine := func(vary ...string) *gin.Engine {
+		g := gin.New()
+		g.Use(Cache(&CacheConfig{Vary: vary}), Compress(nil))
+		g.GET("/data", func(c *gin.Context) {
+			c.Data(http.StatusOK, "application/json", []byte(body))
+		})
+		return g
+	}
+	do := func(g *gin.Engine, encoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/data", nil)
+		if encoding != "" {
+			r.Header.Set("Accept-Encoding", encoding)
+		}
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	// 响应 Vary: Accept-Encoding 不在 CacheConfig.Vary 中，不缓存
+	g := newEngine()
+	do(g, "gzip")
+	if w := do(g, ""); w.Header().Get(CacheStatusHeader) != CacheMiss || w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Fatalf("uncovered vary: %s %q", w.Header().Get(CacheStatusHeader), w.Header().Get("Content-Encoding"))
+	}
+
+	// Accept-Encoding 参与缓存 key，压缩和未压缩的响应分别缓存
+	g = newEngine("Accept-Encoding")
+	do(g, "gzip")
+	if w := do(g, "gzip"); w.Header().Get(CacheStatusHeader) != CacheHit || w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("gzip: %s %q", w.Header().Get(CacheStatusHeader), w.Header().Get("Content-Encoding"))
+	}
+	if w := do(g, ""); w.Header().Get(CacheStatusHeader) != CacheMiss || w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Fatalf("identity: %s %q", w.Header().Get(CacheStatusHeader), w.Header().Get("Content-Encoding"))
+	}
+	if w := do(g, ""); w.Header().Get(CacheStatusHeader) != CacheHit || w.Body.String() != body {
+		t.Fatalf("identity hit: %s", w.Header().Get(CacheStatusHeader))
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	rc := NewResponseCache(&CacheConfig{TTL: xtime.Duration(time.Minute)})
+	key := func(host, target string) string {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Host = host
+		return rc.Key(r)
+	}
+	if key("a.com", "/p?a=1&b=2") == key("a.com", "/p?a=1%26b%3D2") {
+		t.Fatal("escaped query should not share key")
+	}
+	if key("a.com", "/p?b=2&a=1") != key("a.com", "/p?a=1&b=2") {
+		t.Fatal("query order should not change key")
+	}
+	if key("a.com", "/p") == key("b.com", "/p") {
+		t.Fatal("different hosts should not share key")
+	}
+}
+
+func TestMemoryCacheStoreLRU(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryCacheStore(2)
+	_ = s.Set(ctx, "a", &CacheEntry{}, time.Minute)
+	_ = s.Set(ctx, "b", &CacheEntry{}, time.Minute)
+	_, _ = s.Get(ctx, "a")
+	_ = s.Set(ctx, "c", &CacheEntry{}, time.Minute)
+	if e, _ := s.Get(ctx, "b"); e != nil || s.Len() != 2 {
+		t.Fatalf("least recently used entry not evicted, len %d", s.Len())
+	}
+	_ = s.Set(ctx, "d", &CacheEntry{}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if e, _ := s.Get(ctx, "d"); e != nil {
+		t.Fatal("expired entry returned")
+	}
+}
+
+func TestRedisCacheStore(t *testing.T) {
+	addr := fakeRedis(t)
+	s, err := NewRedisCacheStore(&RedisConfig{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	e := &CacheEntry{Status: 200, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"code":200}`), ExpireAt: time.Now().Add(time.Minute)}
+	if err = s.Set(ctx, "k", e, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "k")
+	if err != nil || got == nil || string(got.Body) != string(e.Body) || got.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Get() = %+v, %v", got, err)
+	}
+	if err = s.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.Get(ctx, "k"); got != nil || err != nil {
+		t.Fatalf("Get() after Delete = %+v, %v", got, err)
+	}
+}
+
+// fakeRedis 支持 GET、SET、DEL 和 idempotencyCASScript 的 RESP 服务，忽略过期时间
+func fakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	var (
+		mu   sync.Mutex
+		data = map[string]string{}
+	)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						l, _ := rd.ReadString('\n')
+						size, _ := strconv.Atoi(strings.TrimSpace(l[1:]))
+						buf := make([]byte, size+2)
+						if _, err = io.ReadFull(rd, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:size])
+					}
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							_, _ = conn.Write([]byte("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"))
+						} else {
+							_, _ = conn.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						if _, ok := data[args[1]]; ok && len(args) > 3 && strings.EqualFold(args[3], "NX") {
+							_, _ = conn.Write([]byte("$-1\r\n"))
+							break
+						}
+						data[args[1]] = args[2]
+						_, _ = conn.Write([]byte("+OK\r\n"))
+					case "DEL":
+						delete(data, args[1])
+						_, _ = conn.Write([]byte(":1\r\n"))
+					case "EVAL":
+						// args: script numkeys key token value ttl
+						v, ok := data[args[3]]
+						if !ok || !strings.Contains(v, args[4]) {
+							_, _ = conn.Write([]byte(":0\r\n"))
+							break
+						}
+						if args[5] == "" {
+							delete(data, args[3])
+						} else {
+							data[args[3]] = args[5]
+						}
+						_, _ = conn.Write([]byte(":1\r\n"))
+					default:
+						_, _ = conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
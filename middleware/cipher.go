@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	EncryptAESGCM = "AES-GCM"
+	EncryptRSA    = "RSA"
+)
+
+// EncryptData 按 key.EncryptType 加密报文，返回 base64 密文
+// AES-GCM：base64(nonce|密文)，使用 EncryptKey
+// RSA：base64(RSA-OAEP-SHA256 加密的随机 AES 密钥).base64(nonce|密文)，使用商户公钥 PublicKey
+func EncryptData(key *SignKey, plaintext []byte) (string, error) {
+	switch strings.ToUpper(key.EncryptType) {
+	case EncryptAESGCM:
+		aesKey, err := base64.StdEncoding.DecodeString(key.EncryptKey)
+		if err != nil {
+			return "", err
+		}
+		return sealAESGCM(aesKey, plaintext)
+	case EncryptRSA:
+		pub, err := signPublicKey(key.PublicKey)
+		if err != nil {
+			return "", err
+		}
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("public key type %T is not rsa", pub)
+		}
+		aesKey := make([]byte, 32)
+		if _, err = io.ReadFull(rand.Reader, aesKey); err != nil {
+			return "", err
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, aesKey, nil)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := sealAESGCM(aesKey, plaintext)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(wrapped) + "." + sealed, nil
+	}
+	return "", fmt.Errorf("unsupported encrypt_type(%s)", key.EncryptType)
+}
+
+// DecryptData EncryptData 的逆过程，RSA 使用平台私钥 PrivateKey 解密 AES 密钥
+func DecryptData(key *SignKey, ciphertext string) ([]byte, error) {
+	switch strings.ToUpper(key.EncryptType) {
+	case EncryptAESGCM:
+		aesKey, err := base64.StdEncoding.DecodeString(key.EncryptKey)
+		if err != nil {
+			return nil, err
+		}
+		return openAESGCM(aesKey, ciphertext)
+	case EncryptRSA:
+		wrapped, sealed, ok := strings.Cut(ciphertext, ".")
+		if !ok {
+			return nil, errors.New("invalid rsa ciphertext")
+		}
+		priv, err := signPrivateKey(key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := base64.StdEncoding.DecodeString(wrapped)
+		if err != nil {
+			return nil, err
+		}
+		aesKey, err := rsa.DecryptOAEP(sha256.New(), nil, priv, bs, nil)
+		if err != nil {
+			return nil, err
+		}
+		return openAESGCM(aesKey, sealed)
+	}
+	return nil, fmt.Errorf("unsupported encrypt_type(%s)", key.EncryptType)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealAESGCM(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func openAESGCM(key []byte, ciphertext string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, bs[:gcm.NonceSize()], bs[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEncryptData(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	priv, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	keys := []*SignKey{
+		{EncryptType: EncryptAESGCM, EncryptKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16))},
+		{EncryptType: "rsa", PublicKey: base64.StdEncoding.EncodeToString(der), PrivateKey: base64.StdEncoding.EncodeToString(priv)},
+	}
+	for _, key := range keys {
+		ciphertext, err := EncryptData(key, []byte(`{"amount":1}`))
+		if err != nil {
+			t.Fatalf("%s EncryptData() = %v", key.EncryptType, err)
+		}
+		plaintext, err := DecryptData(key, ciphertext)
+		if err != nil || string(plaintext) != `{"amount":1}` {
+			t.Fatalf("%s DecryptData() = %s, %v", key.EncryptType, plaintext, err)
+		}
+		if _, err = DecryptData(key, ciphertext[:len(ciphertext)-4]+"AAAA"); err == nil {
+			t.Fatalf("%s DecryptData() tampered = nil", key.EncryptType)
+		}
+	}
+}
+
+func TestDecryptRequest(t *testing.T) {
+	key := &SignKey{Secret: "s", EncryptType: EncryptAESGCM, EncryptKey: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))}
+	keys := SignKeyMap{"app": key}
+	g := gin.New()
+	g.POST("/pay", SignVerify(&SignConfig{Keys: keys}), DecryptRequest(&DecryptConfig{Keys: keys}), func(c *gin.Context) {
+		req := &struct {
+			Amount int `json:"amount"`
+		}{}
+		if err := c.ShouldBindJSON(req); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, strconv.Itoa(req.Amount))
+	})
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	post := func(fields map[string]string) *httptest.ResponseRecorder {
+		// 签名针对密文
+		fields["app_id"], fields["timestamp"] = "app", ts
+		fields["sign"], _ = SignContent(key, "", SortedCanonical(fields, nil))
+		bs, _ := json.Marshal(fields)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/pay", bytes.NewReader(bs))
+		r.Header.Set("Content-Type", "application/json")
+		g.ServeHTTP(w, r)
+		return w
+	}
+	ciphertext, _ := EncryptData(key, []byte(`{"amount":300}`))
+	if w := post(map[string]string{"data": ciphertext, "nonce": "n1"}); w.Body.String() != "300" {
+		t.Fatalf("encrypted request = %s", w.Body.String())
+	}
+	if w := post(map[string]string{"amount": "300", "nonce": "n2"}); !strings.Contains(w.Body.String(), DecryptErr.Message()) {
+		t.Fatalf("plaintext request = %s", w.Body.String())
+	}
+}
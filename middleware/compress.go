@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+
+	noCompressKey = "web/no-compress"
+)
+
+var (
+	defaultCompressTypes = []string{"text/*", "application/json", "application/javascript", "application/xml",
+		"application/x-ndjson", "application/problem+json", "image/svg+xml"}
+
+	encoderPools = map[string]*sync.Pool{
+		EncodingGzip: {New: func() any {
+			w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+			return w
+		}},
+		EncodingBrotli: {New: func() any {
+			return brotli.NewWriterLevel(nil, 4)
+		}},
+		EncodingZstd: {New: func() any {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+			return w
+		}},
+	}
+)
+
+type CompressConfig struct {
+	Encodings    []string `json:"encodings" yaml:"encodings" toml:"encodings"`             // 支持的编码，按服务端偏好排序，default br、zstd、gzip
+	MinLength    int      `json:"min_length" yaml:"min_length" toml:"min_length"`          // 小于该大小的响应不压缩，default 1024
+	ContentTypes []string `json:"content_types" yaml:"content_types" toml:"content_types"` // 允许压缩的 Content-Type，支持 text/* 通配，default 文本、json、xml 等
+}
+
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// bodyRecorder 记录未压缩的响应体，例如 AccessLog
+type bodyRecorder interface {
+	recordBody(b []byte)
+}
+
+// Compress gin middleware，按 Accept-Encoding（含 q 值）协商 br、zstd、gzip 压缩响应
+// 需放在 Cache 等会缓存响应体的中间件之前（外层），AccessLog 记录的仍是未压缩的响应体
+func Compress(conf *CompressConfig) gin.HandlerFunc {
+	if conf == nil {
+		conf = &CompressConfig{}
+	}
+	encodings := conf.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+	}
+	for _, e := range encodings {
+		if encoderPools[e] == nil {
+			panic("compress: unsupported encoding " + e)
+		}
+	}
+	types := conf.ContentTypes
+	if len(types) == 0 {
+		types = defaultCompressTypes
+	}
+	minLength := intOr(conf.MinLength, 1024)
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || c.Request.Header.Get("Upgrade") != "" {
+			c.Next()
+			return
+		}
+		encoding := negotiateEncoding(c.Request.Header.Get("Accept-Encoding"), encodings)
+		origin := c.Writer
+		w := &compressWriter{ResponseWriter: origin, c: c, encoding: encoding, types: types, minLength: minLength, status: http.StatusOK}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = origin
+		}()
+		c.Next()
+	}
+}
+
+// NoCompress 关闭当前路由的响应压缩，例如已压缩的文件下载
+func NoCompress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(noCompressKey, true)
+		c.Next()
+	}
+}
+
+// negotiateEncoding 选择 q 值最高的编码，q 值相同时按服务端偏好，无可用编码返回空
+func negotiateEncoding(accept string, encodings []string) string {
+	if accept == "" {
+		return ""
+	}
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		qs[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	best, bestQ := "", 0.0
+	for _, e := range encodings {
+		q, ok := qs[e]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// compressWriter 缓冲到 minLength 后决定是否压缩，Flush 时立即决定以支持流式响应
+type compressWriter struct {
+	gin.ResponseWriter
+	c         *gin.Context
+	encoding  string
+	types     []string
+	minLength int
+
+	status   int
+	buf      bytes.Buffer
+	decided  bool
+	size     int
+	encoder  compressEncoder
+	recorder []bodyRecorder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide()
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.size += len(b)
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.minLength {
+			return len(b), nil
+		}
+		w.decide()
+		return len(b), nil
+	}
+	return w.write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Status() int {
+	if w.decided {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *compressWriter) Size() int {
+	return w.size
+}
+
+func (w *compressWriter) Written() bool {
+	return w.decided
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap 供 http.ResponseController 获取底层 ResponseWriter
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) decide() {
+	w.decided = true
+	h := w.Header()
+	if w.compressible(h) {
+		h.Add("Vary", "Accept-Encoding")
+		if w.encoding != "" && (w.buf.Len() >= w.minLength || isStreamResponse(h)) {
+			w.encoder = encoderPools[w.encoding].Get().(compressEncoder)
+			w.encoder.Reset(w.ResponseWriter)
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+			// 压缩后的表示与原表示字节不同，强 ETag 改为弱 ETag
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			// 响应体已压缩，AccessLog 等改为记录未压缩的数据
+			for rw := http.ResponseWriter(w.ResponseWriter); rw != nil; {
+				if r, ok := rw.(bodyRecorder); ok {
+					w.recorder = append(w.recorder, r)
+				}
+				u, ok := rw.(interface{ Unwrap() http.ResponseWriter })
+				if !ok {
+					break
+				}
+				rw = u.Unwrap()
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		_, _ = w.write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+func (w *compressWriter) compressible(h http.Header) bool {
+	switch {
+	case w.c.GetBool(noCompressKey), h.Get("Content-Encoding") != "",
+		w.status < http.StatusOK, w.status == http.StatusNoContent, w.status == http.StatusPartialContent, w.status == http.StatusNotModified:
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" && w.buf.Len() > 0 {
+		ct = http.DetectContentType(w.buf.Bytes())
+		h.Set("Content-Type", ct)
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, t := range w.types {
+		if prefix, ok := strings.CutSuffix(t, "*"); ok && strings.HasPrefix(mediaType, prefix) || mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *compressWriter) write(b []byte) (int, error) {
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	for _, r := range w.recorder {
+		r.recordBody(b)
+	}
+	if _, err := w.encoder.Write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.buf.Len() == 0 && w.status == http.StatusOK && !w.ResponseWriter.Written() {
+			// handler 未写响应，保持原样由 gin 处理
+			return
+		}
+		w.decide()
+	}
+	if w.encoder == nil {
+		return
+	}
+	_ = w.encoder.Close()
+	w.encoder.Reset(nil)
+	encoderPools[w.encoding].Put(w.encoder)
+	w.encoder = nil
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	all := []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+	tests := []struct {
+		accept, want string
+	}{
+		{"", ""},
+		{"gzip, deflate", EncodingGzip},
+		{"gzip, br", EncodingBrotli},
+		{"gzip;q=1.0, br;q=0.5", EncodingGzip},
+		{"zstd;q=0.8, *;q=0.1", EncodingZstd},
+		{"br;q=0, *", EncodingZstd},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept, all); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := `{"code":200,"message":"` + strings.Repeat("order-1,", 200) + `"}`
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	g := gin.New()
+	// AccessLog 在外层，记录的仍应是未压缩的响应体
+	g.Use(AccessLog("test"), Compress(&CompressConfig{MinLength: 256}))
+	g.GET("/list", func(c *gin.Context) {
+		c.Header("ETag", `"v1"`)
+		c.Data(http.StatusOK, "application/json", []byte(large))
+	})
+	g.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": 200})
+	})
+	g.GET("/png", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte(large))
+	})
+	g.GET("/download", NoCompress(), func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/plain", []byte(large))
+	})
+	do := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		EncodingGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		EncodingBrotli: func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		EncodingZstd: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			return d, err
+		},
+	}
+	for enc, decode := range decoders {
+		w := do("/list", enc)
+		if w.Header().Get("Content-Encoding") != enc || w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `W/"v1"` {
+			t.Fatalf("%s headers = %v", enc, w.Header())
+		}
+		rd, err := decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bs, _ := io.ReadAll(rd); string(bs) != large {
+			t.Fatalf("%s decoded body mismatch, len %d", enc, len(bs))
+		}
+	}
+	if !strings.Contains(logBuf.String(), `"res_msg":"order-1,`) {
+		t.Fatalf("access log body is not uncompressed: %s", logBuf.String())
+	}
+
+	if w := do("/small", "gzip"); w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" || !strings.Contains(w.Body.String(), "200") {
+		t.Fatalf("small = %v %s", w.Header(), w.Body.String())
+	}
+	for _, path := range []string{"/png", "/download"} {
+		if w := do(path, "gzip"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != large {
+			t.Fatalf("%s compressed: %v", path, w.Header())
+		}
+	}
+}
+
+func TestCompressStream(t *testing.T) {
+	release := make(chan struct{})
+	g := gin.New()
+	g.Use(Compress(nil))
+	g.GET("/events", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		_, _ = c.Writer.WriteString("data: first\n\n")
+		c.Writer.Flush()
+		<-release
+	})
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Content-Encoding = %q", resp.Header.Get("Content-Encoding"))
+	}
+	done := make(chan string, 1)
+	go func() {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		line, _ := bufio.NewReader(zr).ReadString('\n')
+		done <- line
+	}()
+	select {
+	case line := <-done:
+		if line != "data: first\n" {
+			t.Fatalf("first event = %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("flushed event not received before handler returned")
+	}
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	l := NewConcurrencyLimiter(&ConcurrencyConfig{MaxInflight: 1, QueueSize: 1, QueueTimeout: xtime.Duration(200 * time.Millisecond)})
+	release := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	g := gin.New()
+	g.Use(l.Handler())
+	g.GET("/:name", func(c *gin.Context) {
+		mu.Lock()
+		order = append(order, c.Param("name"))
+		mu.Unlock()
+		if c.Param("name") == "hold" {
+			<-release
+		}
+		c.String(http.StatusOK, "ok")
+	})
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	waitStats := func(inflight, queued int64) {
+		deadline := time.Now().Add(time.Second)
+		for s := l.Stats(); s.Inflight != inflight || s.Queued != queued; s = l.Stats() {
+			if time.Now().After(deadline) {
+				t.Fatalf("stats = %+v, want inflight %d queued %d", s, inflight, queued)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	holdDone := make(chan struct{})
+	go func() {
+		defer close(holdDone)
+		do("/hold")
+	}()
+	waitStats(1, 0)
+
+	// 排队超时
+	start := time.Now()
+	if w := do("/timeout"); time.Since(start) < 200*time.Millisecond || w.Code != http.StatusOK || w.Body.String() != `{"code":503,"message":"服务器忙，请稍后重试..."}` {
+		t.Fatalf("queue timeout: %d %s", w.Code, w.Body.String())
+	}
+
+	// 客户端断开时退出排队
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan struct{})
+	go func() {
+		defer close(canceled)
+		g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/canceled", nil).WithContext(ctx))
+	}()
+	waitStats(1, 1)
+	// 队列已满时直接拒绝
+	if w := do("/full"); w.Body.String() != `{"code":503,"message":"服务器忙，请稍后重试..."}` {
+		t.Fatalf("queue full: %s", w.Body.String())
+	}
+	cancel()
+	<-canceled
+	waitStats(1, 0)
+
+	// 排队中的请求在名额释放后处理
+	queued := make(chan *httptest.ResponseRecorder, 1)
+	go func() { queued <- do("/queued") }()
+	waitStats(1, 1)
+	close(release)
+	<-holdDone
+	if w := <-queued; w.Body.String() != "ok" {
+		t.Fatalf("queued: %s", w.Body.String())
+	}
+	waitStats(0, 0)
+	if s := l.Stats(); s.Rejected != 3 {
+		t.Fatalf("rejected = %d, want 3", s.Rejected)
+	}
+	if len(order) != 2 || order[0] != "hold" || order[1] != "queued" {
+		t.Fatalf("order = %v", order)
+	}
+}
+
+func TestConcurrencyLimiterFIFO(t *testing.T) {
+	l := NewConcurrencyLimiter(&ConcurrencyConfig{MaxInflight: 1, QueueSize: 3, QueueTimeout: xtime.Duration(time.Second)})
+	if !l.Acquire(nil) {
+		t.Fatal("first acquire failed")
+	}
+	got := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			if l.Acquire(nil) {
+				got <- i
+				l.Release()
+			}
+		}(i)
+		// 保证入队顺序
+		for l.Stats().Queued != int64(i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	l.Release()
+	for want := 0; want < 3; want++ {
+		if i := <-got; i != want {
+			t.Fatalf("got %d, want %d", i, want)
+		}
+	}
+	if s := l.Stats(); s.Inflight != 0 || s.Queued != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
@@ -2,19 +2,53 @@ package middleware
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+type CORSConfig struct {
+	// 允许的 Origin，支持 * 和 *.example.com 通配，为空允许全部
+	AllowOrigins []string `json:"allow_origins" yaml:"allow_origins" toml:"allow_origins"`
+}
+
+// AllowOrigin 校验 origin 是否允许跨域，origin 可以是完整的 Origin 头或 host，*.example.com 通配时忽略端口
+func (c *CORSConfig) AllowOrigin(origin string) bool {
+	if c == nil || len(c.AllowOrigins) == 0 {
+		return true
+	}
+	host := origin
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	for _, o := range c.AllowOrigins {
+		switch {
+		case o == "*":
+			return true
+		case strings.EqualFold(o, origin), strings.EqualFold(o, host):
+			return true
+		case strings.HasPrefix(o, "*.") && strings.HasSuffix(strings.ToLower(stripPort(host)), strings.ToLower(o[1:])):
+			return true
+		}
+	}
+	return false
+}
+
 // CORS gin middleware cors
 func CORS() gin.HandlerFunc {
+	return CORSWithConfig(nil)
+}
+
+// CORSWithConfig gin middleware cors, if conf is nil, allow all origin
+func CORSWithConfig(conf *CORSConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		method := c.Request.Method
 		origin := c.Request.Header.Get("Origin") // 请求头部
 		if origin == "" {
 			origin = c.Request.Host
 		}
-		if origin != "" {
+		if origin != "" && conf.AllowOrigin(origin) {
 			c.Header("Access-Control-Allow-Origin", origin)
 			// 允许跨域返回的Header
 			c.Header("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, Content-Length, X-CSRF-Token, Token, Session, Origin, Host, Connection, Accept-Encoding, Accept-Language, X-Requested-With")
@@ -30,7 +64,10 @@ func CORS() gin.HandlerFunc {
 		if method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
 		}
-		c.Request.Header.Del("Origin")
+		// websocket 握手需要保留 Origin 做来源校验
+		if !strings.EqualFold(c.Request.Header.Get("Upgrade"), "websocket") {
+			c.Request.Header.Del("Origin")
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,23 @@
+package middleware
+
+import "testing"
+
+func TestCORSAllowOrigin(t *testing.T) {
+	c := &CORSConfig{AllowOrigins: []string{"https://pay.gopay.ink", "localhost:3000", "*.example.com"}}
+	cases := map[string]bool{
+		"https://pay.gopay.ink":      true,
+		"http://localhost:3000":      true,
+		"http://localhost:3001":      false,
+		"https://a.example.com":      true,
+		"https://a.example.com:8443": true,
+		"a.example.com:8443":         true,
+		"https://example.com.evil":   false,
+		"https://evil-example.com":   false,
+		"https://a.example.com.evil": false,
+	}
+	for origin, want := range cases {
+		if got := c.AllowOrigin(origin); got != want {
+			t.Errorf("AllowOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+//go:build linux
+
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuReader 返回读取累计 CPU 繁忙时间和可用时间的函数，容器设置了 cgroup v2 CPU 配额时按配额计算，否则使用 /proc/stat
+// 数据来源在启动时确定，避免采样期间切换来源导致计数不连续
+func cpuReader() func() (busy, total uint64, err error) {
+	dir := cgroupDir()
+	if _, _, err := readCgroupCPU(dir); err == nil {
+		return func() (uint64, uint64, error) { return readCgroupCPU(dir) }
+	}
+	return readProcStat
+}
+
+// cgroupDir 当前进程所在的 cgroup v2 目录，不存在时使用根目录（容器内开启 cgroup namespace 时两者相同）
+func cgroupDir() string {
+	bs, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return cgroupRoot
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if p, ok := strings.CutPrefix(line, "0::"); ok {
+			dir := filepath.Join(cgroupRoot, p)
+			if _, err = os.Stat(filepath.Join(dir, "cpu.stat")); err == nil {
+				return dir
+			}
+		}
+	}
+	return cgroupRoot
+}
+
+// readCgroupCPU 读取 cpu.stat 的 usage_usec 和 cpu.max 的配额，可用时间为当前时间（微秒）× 配额核数，未设置配额时返回错误
+func readCgroupCPU(dir string) (busy, total uint64, err error) {
+	bs, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(bs))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, 0, errors.New("cgroup cpu quota not set")
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || quota <= 0 || period <= 0 {
+		return 0, 0, fmt.Errorf("invalid cgroup cpu.max(%s)", strings.TrimSpace(string(bs)))
+	}
+	f, err := os.Open(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "usage_usec "); ok {
+			if busy, err = strconv.ParseUint(strings.TrimSpace(v), 10, 64); err != nil {
+				return 0, 0, err
+			}
+			return busy, uint64(float64(time.Now().UnixMicro()) * quota / period), nil
+		}
+	}
+	return 0, 0, errors.New("usage_usec not found in cgroup cpu.stat")
+}
+
+// readProcStat /proc/stat 中所有 CPU 的繁忙时间和累计时间
+func readProcStat() (busy, total uint64, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return 0, 0, errors.New("empty /proc/stat")
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.New("invalid /proc/stat")
+	}
+	var idle uint64
+	for i, v := range fields[1:] {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += n
+		// idle、iowait
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+	return total - idle, total, nil
+}
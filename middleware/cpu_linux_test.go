@@ -0,0 +1,35 @@
+//go:build linux
+
+package middleware
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadCgroupCPU(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("cpu.stat", "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n")
+	write("cpu.max", "max 100000\n")
+	if _, _, err := readCgroupCPU(dir); err == nil {
+		t.Fatal("cpu.max without quota should fail")
+	}
+
+	write("cpu.max", "200000 100000\n")
+	busy, total, err := readCgroupCPU(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 配额 2 核，可用时间为当前时间的 2 倍
+	now := uint64(time.Now().UnixMicro()) * 2
+	if busy != 1500000 || total > now || now-total > uint64(2*time.Second/time.Microsecond) {
+		t.Fatalf("readCgroupCPU = %d, %d, now %d", busy, total, now)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package middleware
+
+import "errors"
+
+func cpuReader() func() (busy, total uint64, err error) {
+	return func() (uint64, uint64, error) {
+		return 0, 0, errors.New("cpu usage is only supported on linux")
+	}
+}
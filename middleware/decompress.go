@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xlog"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	UnsupportedEncodingErr = ecode.New(http.StatusUnsupportedMediaType, "UNSUPPORTED_ENCODING", "unsupported content encoding")
+	RequestTooLargeErr     = ecode.New(http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", "request body too large")
+
+	gzipReaderPool sync.Pool
+)
+
+type DecompressConfig struct {
+	MaxSize int64 `json:"max_size" yaml:"max_size" toml:"max_size"` // 解压后请求体最大字节数，超过返回 413，default 10MB
+}
+
+// Decompress gin middleware，解压 Content-Encoding 为 gzip、deflate、zstd 的请求体，需在 Recovery 之后、AccessLog 之前注册
+// 不支持的编码返回 415，解压失败返回 400，解压后超过 MaxSize 返回 413
+func Decompress(conf *DecompressConfig) gin.HandlerFunc {
+	if conf == nil {
+		conf = &DecompressConfig{}
+	}
+	maxSize := conf.MaxSize
+	if maxSize <= 0 {
+		maxSize = 10 << 20
+	}
+	zstdPool := &sync.Pool{New: func() any {
+		d, err := newZstdDecoder(maxSize)
+		if err != nil {
+			xlog.Errorf("decompress: create zstd decoder error: %v", err)
+			return nil
+		}
+		return d
+	}}
+	// 配置错误时在注册阶段 panic
+	d, err := newZstdDecoder(maxSize)
+	if err != nil {
+		panic(fmt.Sprintf("decompress: create zstd decoder error: %v", err))
+	}
+	zstdPool.Put(d)
+	return func(c *gin.Context) {
+		ce := strings.TrimSpace(c.Request.Header.Get("Content-Encoding"))
+		if ce == "" || strings.EqualFold(ce, "identity") || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		encodings := strings.Split(ce, ",")
+		for _, e := range encodings {
+			switch strings.ToLower(strings.TrimSpace(e)) {
+			case EncodingGzip, "x-gzip", "deflate", EncodingZstd, "identity":
+			default:
+				c.Header("Accept-Encoding", "gzip, deflate, zstd")
+				writeErrorStatus(c.Writer, http.StatusUnsupportedMediaType, UnsupportedEncodingErr)
+				c.Abort()
+				return
+			}
+		}
+		body, err := decompressBody(c.Request.Body, encodings, maxSize, zstdPool)
+		_ = c.Request.Body.Close()
+		if err != nil {
+			switch {
+			case errors.Is(err, errBodyTooLarge):
+				writeErrorStatus(c.Writer, http.StatusRequestEntityTooLarge, RequestTooLargeErr)
+			case errors.Is(err, errNoZstdDecoder):
+				writeErrorStatus(c.Writer, http.StatusInternalServerError, ecode.ServerErr.WithCause(err))
+			default:
+				writeErrorStatus(c.Writer, http.StatusBadRequest, ecode.RequestErr.WithCause(err))
+			}
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		c.Next()
+	}
+}
+
+var (
+	errBodyTooLarge  = errors.New("decompressed body too large")
+	errNoZstdDecoder = errors.New("zstd decoder unavailable")
+)
+
+func newZstdDecoder(maxSize int64) (*zstd.Decoder, error) {
+	return zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderLowmem(true),
+		// 限制窗口和内存，防止构造的帧头声明超大窗口
+		zstd.WithDecoderMaxWindow(8<<20),
+		zstd.WithDecoderMaxMemory(uint64(maxSize)+1),
+	)
+}
+
+// decompressBody 多个编码按应用顺序的逆序解码
+func decompressBody(r io.Reader, encodings []string, maxSize int64, zstdPool *sync.Pool) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case EncodingGzip, "x-gzip":
+			zr, ok := gzipReaderPool.Get().(*gzip.Reader)
+			if !ok {
+				zr = new(gzip.Reader)
+			}
+			if err := zr.Reset(r); err != nil {
+				return nil, err
+			}
+			defer gzipReaderPool.Put(zr)
+			r = zr
+		case "deflate":
+			br := bufio.NewReader(r)
+			// HTTP deflate 为 zlib 格式，兼容部分客户端发送的原始 deflate
+			if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+				zr, err := zlib.NewReader(br)
+				if err != nil {
+					return nil, err
+				}
+				defer zr.Close()
+				r = zr
+			} else {
+				fr := flate.NewReader(br)
+				defer fr.Close()
+				r = fr
+			}
+		case EncodingZstd:
+			d, _ := zstdPool.Get().(*zstd.Decoder)
+			if d == nil {
+				return nil, errNoZstdDecoder
+			}
+			if err := d.Reset(r); err != nil {
+				return nil, err
+			}
+			defer func() {
+				_ = d.Reset(nil)
+				zstdPool.Put(d)
+			}()
+			r = d
+		}
+	}
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSize {
+		return nil, errBodyTooLarge
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/web/metadata"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompress(t *testing.T) {
+	payload := `{"out_trade_no":"T-1","amount":100}`
+	encode := map[string]func(w io.Writer) io.WriteCloser{
+		"gzip":         func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate":      func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"zstd":         func(w io.Writer) io.WriteCloser { e, _ := zstd.NewWriter(w); return e },
+		"deflate(raw)": func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw },
+	}
+	compress := func(name, s string) []byte {
+		var buf bytes.Buffer
+		w := encode[name](&buf)
+		_, _ = w.Write([]byte(s))
+		_ = w.Close()
+		return buf.Bytes()
+	}
+
+	var got string
+	g := gin.New()
+	g.Use(Decompress(&DecompressConfig{MaxSize: 1024}))
+	g.POST("/notify", func(c *gin.Context) {
+		bs, _ := metadata.RequestBody(c.Request)
+		got = string(bs)
+		c.String(http.StatusOK, "ok")
+	})
+	do := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewReader(body))
+		r.Header.Set("Content-Encoding", encoding)
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	for name := range encode {
+		got = ""
+		encoding := strings.TrimSuffix(name, "(raw)")
+		if w := do(encoding, compress(name, payload)); w.Code != http.StatusOK || got != payload {
+			t.Fatalf("%s = %d, body %q", name, w.Code, got)
+		}
+	}
+	if w := do("br", []byte("x")); w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Encoding") == "" {
+		t.Fatalf("unsupported encoding = %d", w.Code)
+	}
+	// 解压后超过 MaxSize
+	if w := do("gzip", compress("gzip", strings.Repeat("0", 4096))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("zip bomb = %d", w.Code)
+	}
+	if w := do("gzip", []byte("not gzip")); w.Code != http.StatusBadRequest {
+		t.Fatalf("corrupted body = %d", w.Code)
+	}
+}
+
+func TestDecompressNoZstdDecoder(t *testing.T) {
+	// 解码器创建失败时 Pool 返回 nil，不应 panic
+	if _, err := decompressBody(strings.NewReader("x"), []string{EncodingZstd}, 1024, &sync.Pool{}); !errors.Is(err, errNoZstdDecoder) {
+		t.Fatalf("decompressBody err = %v, want errNoZstdDecoder", err)
+	}
+}
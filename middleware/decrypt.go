@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+)
+
+var DecryptErr = ecode.New(http.StatusBadRequest, "DECRYPT_ERROR", "decrypt request failed")
+
+type DecryptConfig struct {
+	AppIdField string `json:"app_id_field" yaml:"app_id_field" toml:"app_id_field"` // 未经过 SignVerify 时获取 app_id 的字段，default app_id，支持 header: 前缀
+	DataField  string `json:"data_field" yaml:"data_field" toml:"data_field"`       // 密文字段，default data
+
+	Keys SignKeyStore `json:"-" yaml:"-" toml:"-"` // 必填，通常与 SignVerify 共用
+}
+
+// DecryptRequest gin middleware，解密 JSON 请求体中的密文字段，并以明文替换请求体，handler 可直接绑定
+// 签名针对密文计算，需放在 SignVerify 之后；app 未配置 EncryptType 时不处理，已配置时拒绝明文请求
+func DecryptRequest(conf *DecryptConfig) gin.HandlerFunc {
+	if conf == nil || conf.Keys == nil {
+		panic("decrypt request: keys is nil")
+	}
+	appIdField := stringOr(conf.AppIdField, "app_id")
+	dataField := stringOr(conf.DataField, "data")
+	return func(c *gin.Context) {
+		plaintext, err := decryptRequest(c, conf.Keys, appIdField, dataField)
+		if err != nil {
+			writeError(c.Writer, err)
+			c.Abort()
+			return
+		}
+		if plaintext != nil {
+			c.Request.Body = io.NopCloser(bytes.NewReader(plaintext))
+			c.Request.ContentLength = int64(len(plaintext))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Request.Header.Set("Content-Length", strconv.Itoa(len(plaintext)))
+		}
+		c.Next()
+	}
+}
+
+// decryptRequest 返回 nil, nil 表示无需解密
+func decryptRequest(c *gin.Context, keys SignKeyStore, appIdField, dataField string) ([]byte, error) {
+	var (
+		body   []byte
+		fields map[string]json.RawMessage
+		err    error
+	)
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		if body, err = metadata.RequestBody(c.Request); err != nil {
+			return nil, ecode.RequestErr.WithCause(err)
+		}
+		// 非 JSON 请求体在确认 app 需要解密后再报错
+		_ = json.Unmarshal(body, &fields)
+	}
+	appId := SignAppId(c)
+	if appId == "" {
+		if h, ok := strings.CutPrefix(appIdField, "header:"); ok {
+			appId = c.GetHeader(h)
+		} else if raw, ok := fields[appIdField]; ok {
+			_ = json.Unmarshal(raw, &appId)
+		}
+	}
+	if appId == "" {
+		return nil, SignAppNotFoundErr
+	}
+	key, err := keys.SignKey(c.Request.Context(), appId)
+	if err != nil {
+		return nil, ecode.ServerErr.WithCause(err)
+	}
+	if key == nil {
+		return nil, SignAppNotFoundErr
+	}
+	if key.EncryptType == "" {
+		return nil, nil
+	}
+	var ciphertext string
+	if raw, ok := fields[dataField]; !ok || json.Unmarshal(raw, &ciphertext) != nil || ciphertext == "" {
+		return nil, DecryptErr.WithCause(errors.New("ciphertext missing"))
+	}
+	plaintext, err := DecryptData(key, ciphertext)
+	if err != nil {
+		return nil, DecryptErr.WithCause(err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+)
+
+var PreconditionFailedErr = ecode.New(http.StatusPreconditionFailed, "PRECONDITION_FAILED", "precondition failed")
+
+type ETagConfig struct {
+	Weak        bool `json:"weak" yaml:"weak" toml:"weak"`                            // 生成弱 ETag W/"..."，响应体语义相同但字节可能不同时使用
+	MaxBodySize int  `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size"` // 超过该大小的响应不缓冲、不生成 ETag，default 1MB
+}
+
+// ETag gin middleware，缓冲 GET、HEAD 的 200 响应并按响应体生成 ETag，处理 If-None-Match、If-Match、If-Modified-Since
+// handler 已设置 ETag（例如通过 Conditional）时使用 handler 的 ETag
+func ETag(conf *ETagConfig) gin.HandlerFunc {
+	if conf == nil {
+		conf = &ETagConfig{}
+	}
+	limit := intOr(conf.MaxBodySize, 1<<20)
+	return func(c *gin.Context) {
+		if m := c.Request.Method; m != http.MethodGet && m != http.MethodHead {
+			c.Next()
+			return
+		}
+		origin := c.Writer
+		w := &etagWriter{ResponseWriter: origin, status: http.StatusOK, limit: limit}
+		c.Writer = w
+		defer func() { c.Writer = origin }()
+		c.Next()
+		if !w.passthrough {
+			w.finish(c.Request, conf.Weak)
+		}
+	}
+}
+
+// Conditional 设置 ETag、Last-Modified 并按 RFC 9110 校验条件请求，不需要缓冲响应
+// etag 为资源版本号，未加引号时自动加引号，为空或 lastModified 为零值时不设置对应头
+// 返回 false 时已响应 304 或 412，handler 应直接返回；PUT、PATCH 更新前调用可实现乐观锁
+func Conditional(c *gin.Context, etag string, lastModified time.Time) bool {
+	if etag != "" && !strings.HasSuffix(etag, `"`) {
+		etag = `"` + etag + `"`
+	}
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	switch checkPreconditions(c.Request, etag, lastModified) {
+	case http.StatusNotModified:
+		c.AbortWithStatus(http.StatusNotModified)
+		return false
+	case http.StatusPreconditionFailed:
+		writeErrorStatus(c.Writer, http.StatusPreconditionFailed, PreconditionFailedErr)
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// checkPreconditions RFC 9110 13.2.2 的校验顺序，返回 0、304 或 412
+func checkPreconditions(r *http.Request, etag string, lastModified time.Time) int {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	get := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag, true) {
+			if get {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && get && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(ims) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatch weak 为 true 时使用弱比较（If-None-Match），否则使用强比较（If-Match）
+func etagMatch(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if v == etag && !strings.HasPrefix(v, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter 缓冲响应，超过 limit、流式响应或 handler 主动 Flush 时改为直接输出
+type etagWriter struct {
+	gin.ResponseWriter
+	buf         bytes.Buffer
+	status      int
+	limit       int
+	passthrough bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *etagWriter) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.passthrough && (w.buf.Len()+len(b) > w.limit || isStreamResponse(w.Header())) {
+		w.startPassthrough()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *etagWriter) Status() int {
+	if w.passthrough {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *etagWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+	return w.buf.Len()
+}
+
+func (w *etagWriter) Written() bool {
+	return w.passthrough && w.ResponseWriter.Written()
+}
+
+func (w *etagWriter) Flush() {
+	if !w.passthrough {
+		w.startPassthrough()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap 供 http.ResponseController 获取底层 ResponseWriter
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *etagWriter) startPassthrough() {
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+func (w *etagWriter) finish(r *http.Request, weak bool) {
+	h := w.Header()
+	if w.status == http.StatusOK {
+		etag := h.Get("ETag")
+		if etag == "" {
+			sum := sha256.Sum256(w.buf.Bytes())
+			etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+			if weak {
+				etag = "W/" + etag
+			}
+			h.Set("ETag", etag)
+		}
+		lastModified, _ := http.ParseTime(h.Get("Last-Modified"))
+		switch checkPreconditions(r, etag, lastModified) {
+		case http.StatusNotModified:
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.ResponseWriter.WriteHeader(http.StatusNotModified)
+			w.ResponseWriter.WriteHeaderNow()
+			return
+		case http.StatusPreconditionFailed:
+			h.Del("ETag")
+			writeErrorStatus(w.ResponseWriter, http.StatusPreconditionFailed, PreconditionFailedErr)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+}
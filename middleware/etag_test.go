@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestETag(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	g := gin.New()
+	g.Use(ETag(&ETagConfig{MaxBodySize: 64}))
+	g.GET("/rate", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": 200, "data": "7.1"})
+	})
+	g.GET("/large", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat("x", 100))
+	})
+	g.GET("/order", func(c *gin.Context) {
+		if !Conditional(c, "v3", modTime) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 200})
+	})
+	g.PUT("/order", func(c *gin.Context) {
+		if !Conditional(c, "v3", time.Time{}) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"code": 200})
+	})
+	do := func(method, path string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodGet, "/rate")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || !strings.Contains(w.Body.String(), "7.1") {
+		t.Fatalf("GET = %d %q %s", w.Code, etag, w.Body.String())
+	}
+	if w = do(http.MethodGet, "/rate", "If-None-Match", `"other", `+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("If-None-Match = %d %s", w.Code, w.Body.String())
+	}
+	if w = do(http.MethodGet, "/rate", "If-Match", `"other"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match = %d", w.Code)
+	}
+	if w = do(http.MethodGet, "/large", "If-None-Match", "*"); w.Code != http.StatusOK || w.Header().Get("ETag") != "" || w.Body.Len() != 100 {
+		t.Fatalf("large body = %d %q %d", w.Code, w.Header().Get("ETag"), w.Body.Len())
+	}
+
+	if w = do(http.MethodGet, "/order"); w.Header().Get("ETag") != `"v3"` || w.Header().Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+		t.Fatalf("Conditional headers = %v", w.Header())
+	}
+	if w = do(http.MethodGet, "/order", "If-Modified-Since", modTime.Format(http.TimeFormat)); w.Code != http.StatusNotModified {
+		t.Fatalf("If-Modified-Since = %d", w.Code)
+	}
+	if w = do(http.MethodPut, "/order", "If-Match", `"v2"`); w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), `"code":412`) {
+		t.Fatalf("PUT stale If-Match = %d %s", w.Code, w.Body.String())
+	}
+	if w = do(http.MethodPut, "/order", "If-Match", `"v3"`); w.Code != http.StatusOK {
+		t.Fatalf("PUT If-Match = %d", w.Code)
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		weak, want   bool
+	}{
+		{`"a"`, `"a"`, false, true},
+		{`W/"a"`, `"a"`, false, false},
+		{`W/"a"`, `"a"`, true, true},
+		{`"b", W/"a"`, `W/"a"`, true, true},
+		{`*`, `"a"`, false, true},
+		{`*`, ``, true, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.header, tt.etag, tt.weak); got != tt.want {
+			t.Errorf("etagMatch(%q, %q, %v) = %v, want %v", tt.header, tt.etag, tt.weak, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+)
+
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+var (
+	IdempotencyKeyMissingErr = ecode.New(http.StatusBadRequest, "IDEMPOTENCY_KEY_MISSING", "idempotency key missing")
+	IdempotencyConflictErr   = ecode.New(http.StatusConflict, "IDEMPOTENCY_IN_PROGRESS", "request with the same idempotency key is in progress")
+	IdempotencyMismatchErr   = ecode.New(http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "idempotency key reused with different request")
+	IdempotencyOmittedErr    = ecode.New(http.StatusConflict, "IDEMPOTENCY_RESPONSE_OMITTED", "request with the same idempotency key already completed, response not stored")
+)
+
+type IdempotencyConfig struct {
+	Header      string         `json:"header" yaml:"header" toml:"header"`                      // default Idempotency-Key
+	Required    bool           `json:"required" yaml:"required" toml:"required"`                // 缺少 key 时返回 400，default 不校验幂等直接处理
+	Methods     []string       `json:"methods" yaml:"methods" toml:"methods"`                   // default POST、PATCH
+	TTL         xtime.Duration `json:"ttl" yaml:"ttl" toml:"ttl"`                               // 响应保存时长，default 24h
+	LockTTL     xtime.Duration `json:"lock_ttl" yaml:"lock_ttl" toml:"lock_ttl"`                // 处理中记录的过期时间，处理期间每 1/3 LockTTL 续期，进程退出后 key 在 LockTTL 后释放，default 1m
+	KeyPrefix   string         `json:"key_prefix" yaml:"key_prefix" toml:"key_prefix"`          // default web:idempotency:
+	MaxBodySize int            `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size"` // 可保存的最大响应体，超过时只记录已完成，重复请求返回 409，default 1MB
+
+	Store IdempotencyStore            `json:"-" yaml:"-" toml:"-"` // default 进程内存储，多实例部署请使用 RedisIdempotencyStore
+	Scope func(c *gin.Context) string `json:"-" yaml:"-" toml:"-"` // key 的作用域，例如商户号、用户 id，避免不同调用方的 key 冲突
+}
+
+// Idempotency gin middleware，按 Idempotency-Key 保存首次响应并对重复请求重放
+// 同一 key 处理中或已完成但响应未保存的重复请求返回 409，key 相同但请求方法、路径、请求体不同时返回 422
+// 响应为 5xx、统一响应结构的 code 为 5xx 或 handler panic 时删除记录，允许客户端重试
+func Idempotency(conf *IdempotencyConfig) gin.HandlerFunc {
+	if conf == nil {
+		conf = &IdempotencyConfig{}
+	}
+	header := stringOr(conf.Header, "Idempotency-Key")
+	methods := conf.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	ttl := durationOr(conf.TTL, 24*time.Hour)
+	lockTTL := durationOr(conf.LockTTL, time.Minute)
+	prefix := stringOr(conf.KeyPrefix, "web:idempotency:")
+	limit := intOr(conf.MaxBodySize, 1<<20)
+	store := conf.Store
+	if store == nil {
+		store = NewMemoryIdempotencyStore()
+	}
+	return func(c *gin.Context) {
+		match := false
+		for _, m := range methods {
+			if strings.EqualFold(m, c.Request.Method) {
+				match = true
+				break
+			}
+		}
+		idemKey := c.GetHeader(header)
+		if !match || idemKey == "" {
+			if match && conf.Required {
+				writeErrorStatus(c.Writer, http.StatusBadRequest, IdempotencyKeyMissingErr)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		key := prefix
+		if conf.Scope != nil {
+			key += conf.Scope(c) + ":"
+		}
+		key += idemKey
+
+		hash, err := idempotencyHash(c.Request)
+		if err != nil {
+			writeError(c.Writer, ecode.RequestErr.WithCause(err))
+			c.Abort()
+			return
+		}
+		ctx := context.WithoutCancel(c.Request.Context())
+		lockRec := &IdempotencyRecord{Token: NewIdempotencyToken(), RequestHash: hash, CreatedAt: time.Now()}
+		existing, locked, err := store.Lock(ctx, key, lockRec, lockTTL)
+		if err != nil {
+			xlog.Errorf("idempotency lock %s error: %v", key, err)
+			writeError(c.Writer, ecode.ServerErr.WithCause(err))
+			c.Abort()
+			return
+		}
+		if !locked {
+			switch {
+			case existing.RequestHash != hash:
+				writeErrorStatus(c.Writer, http.StatusUnprocessableEntity, IdempotencyMismatchErr)
+			case !existing.Completed:
+				c.Header("Retry-After", "1")
+				writeErrorStatus(c.Writer, http.StatusConflict, IdempotencyConflictErr)
+			case existing.Omitted:
+				writeErrorStatus(c.Writer, http.StatusConflict, IdempotencyOmittedErr)
+			default:
+				replayIdempotent(c, existing)
+			}
+			c.Abort()
+			return
+		}
+
+		origin := c.Writer
+		w := &cacheWriter{ResponseWriter: origin, limit: limit}
+		c.Writer = w
+		stopRenew := renewIdempotencyLock(ctx, store, key, lockRec, lockTTL)
+		saved := false
+		defer func() {
+			stopRenew()
+			c.Writer = origin
+			// handler panic 或服务端错误时释放 key，允许客户端重试
+			if !saved {
+				if err := store.Delete(ctx, key, lockRec.Token); err != nil {
+					xlog.Warnf("idempotency delete %s error: %v", key, err)
+				}
+			}
+		}()
+		c.Next()
+
+		if idempotentRetryable(w) {
+			return
+		}
+		stopRenew()
+		rec := &IdempotencyRecord{Token: lockRec.Token, RequestHash: hash, Completed: true, CreatedAt: time.Now()}
+		if w.overflow || isStreamResponse(w.Header()) {
+			rec.Omitted = true
+		} else {
+			rec.Status = w.Status()
+			rec.Header = w.Header().Clone()
+			for _, k := range append([]string{"Content-Length", "Date"}, hopHeaders...) {
+				rec.Header.Del(k)
+			}
+			rec.Body = append([]byte(nil), w.body.Bytes()...)
+		}
+		if err = store.Save(ctx, key, rec, ttl); err != nil {
+			xlog.Errorf("idempotency save %s error: %v", key, err)
+			return
+		}
+		saved = true
+	}
+}
+
+// idempotencyHash 请求方法、路径和请求体的哈希，同一 key 用于不同请求时返回 422
+func idempotencyHash(r *http.Request) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := metadata.RequestBody(r)
+		if err != nil {
+			return "", err
+		}
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// renewIdempotencyLock 处理期间定期续期处理中的记录，避免耗时超过 LockTTL 的请求被重复执行，返回的 stop 可重复调用
+func renewIdempotencyLock(ctx context.Context, store IdempotencyStore, key string, rec *IdempotencyRecord, ttl time.Duration) (stop func()) {
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			if err := store.Save(ctx, key, rec, ttl); err != nil {
+				xlog.Warnf("idempotency renew %s error: %v", key, err)
+				if errors.Is(err, ErrIdempotencyLockLost) {
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-done
+		})
+	}
+}
+
+// idempotentRetryable 服务端错误不保存记录，允许客户端重试
+func idempotentRetryable(w *cacheWriter) bool {
+	if w.Status() >= http.StatusInternalServerError {
+		return true
+	}
+	if !w.overflow && strings.Contains(w.Header().Get("Content-Type"), "json") {
+		// 统一响应结构的服务端错误也是 HTTP 200
+		var rsp struct {
+			Code *int `json:"code"`
+		}
+		if json.Unmarshal(w.body.Bytes(), &rsp) == nil && rsp.Code != nil && *rsp.Code >= 500 && *rsp.Code < 600 {
+			return true
+		}
+	}
+	return false
+}
+
+func replayIdempotent(c *gin.Context, rec *IdempotencyRecord) {
+	h := c.Writer.Header()
+	for k, v := range rec.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	h.Set("Content-Length", strconv.Itoa(len(rec.Body)))
+	c.Writer.WriteHeader(rec.Status)
+	c.Writer.WriteHeaderNow()
+	_, _ = c.Writer.Write(rec.Body)
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyLockLost 记录已过期或被其他请求重新锁定，Save 未写入
+var ErrIdempotencyLockLost = errors.New("idempotency lock lost")
+
+// IdempotencyStore 幂等记录存储，Save、Delete 仅在 Token 与当前记录一致时生效，避免覆盖或删除其他请求的记录
+type IdempotencyStore interface {
+	// Lock key 不存在时写入 rec 并返回 nil, true，已存在时返回已有记录和 false
+	Lock(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	// Save 当前记录的 Token 与 rec.Token 一致时覆盖写入，用于续期处理中的记录和保存已完成的记录，不一致时返回 ErrIdempotencyLockLost
+	Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error
+	// Delete 当前记录的 Token 与 token 一致时删除
+	Delete(ctx context.Context, key, token string) error
+}
+
+// IdempotencyRecord 幂等记录，Completed 为 false 表示请求处理中
+type IdempotencyRecord struct {
+	Token       string      `json:"token"` // 加锁请求的随机标识
+	RequestHash string      `json:"request_hash"`
+	Completed   bool        `json:"completed"`
+	Omitted     bool        `json:"omitted,omitempty"` // 已完成但响应过大或为流式响应，未保存响应
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// NewIdempotencyToken 生成 IdempotencyRecord.Token
+func NewIdempotencyToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MemoryIdempotencyStore 进程内存储，仅适用于单实例部署
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	items     map[string]*memoryIdempotencyItem
+	lastSweep time.Time
+}
+
+type memoryIdempotencyItem struct {
+	rec      *IdempotencyRecord
+	deadline time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{items: make(map[string]*memoryIdempotencyItem), lastSweep: time.Now()}
+}
+
+func (s *MemoryIdempotencyStore) Lock(_ context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 定期清理过期记录
+	if now.Sub(s.lastSweep) >= time.Minute {
+		for k, item := range s.items {
+			if now.After(item.deadline) {
+				delete(s.items, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if item, ok := s.items[key]; ok && !now.After(item.deadline) {
+		return item.rec, false, nil
+	}
+	s.items[key] = &memoryIdempotencyItem{rec: rec, deadline: now.Add(ttl)}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[key]; !ok || now.After(item.deadline) || item.rec.Token != rec.Token {
+		return ErrIdempotencyLockLost
+	}
+	s.items[key] = &memoryIdempotencyItem{rec: rec, deadline: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Delete(_ context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[key]; ok && item.rec.Token == token {
+		delete(s.items, key)
+	}
+	return nil
+}
+
+// RedisIdempotencyStore 基于 Redis 协议的存储，多实例共享
+type RedisIdempotencyStore struct {
+	cli *redisClient
+}
+
+func NewRedisIdempotencyStore(conf *RedisConfig) (*RedisIdempotencyStore, error) {
+	cli, err := newRedisClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisIdempotencyStore{cli: cli}, nil
+}
+
+func (s *RedisIdempotencyStore) Lock(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	// SET NX 失败后读取已有记录，记录恰好过期时重试一次
+	for i := 0; i < 2; i++ {
+		reply, err := s.cli.do(ctx, "SET", key, bs, "NX", "PX", redisTTL(ttl))
+		if err != nil {
+			return nil, false, err
+		}
+		if reply != nil {
+			return nil, true, nil
+		}
+		if reply, err = s.cli.do(ctx, "GET", key); err != nil {
+			return nil, false, err
+		}
+		if str, ok := reply.(string); ok {
+			existing := &IdempotencyRecord{}
+			if err = json.Unmarshal([]byte(str), existing); err != nil {
+				return nil, false, err
+			}
+			return existing, false, nil
+		}
+	}
+	return nil, false, errors.New("idempotency key contended")
+}
+
+// idempotencyCASScript KEYS[1] 的记录包含 token ARGV[1] 时，ARGV[2] 为空则删除，否则写入 ARGV[2] 并设置过期时间 ARGV[3] 毫秒
+const idempotencyCASScript = `local v = redis.call('GET', KEYS[1])
+if not v or not string.find(v, ARGV[1], 1, true) then return 0 end
+if ARGV[2] == '' then redis.call('DEL', KEYS[1]) else redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3]) end
+return 1`
+
+func (s *RedisIdempotencyStore) Save(ctx context.Context, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ok, err := s.cas(ctx, key, rec.Token, string(bs), redisTTL(ttl))
+	if err == nil && !ok {
+		err = ErrIdempotencyLockLost
+	}
+	return err
+}
+
+func (s *RedisIdempotencyStore) Delete(ctx context.Context, key, token string) error {
+	_, err := s.cas(ctx, key, token, "", 0)
+	return err
+}
+
+func (s *RedisIdempotencyStore) cas(ctx context.Context, key, token, value string, ttl int64) (bool, error) {
+	if token == "" || strings.ContainsAny(token, `"\`) {
+		return false, errors.New("invalid idempotency token")
+	}
+	reply, err := s.cli.do(ctx, "EVAL", idempotencyCASScript, 1, key, `"token":"`+token+`"`, value, ttl)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n == 1, nil
+}
+
+// Close 关闭空闲连接
+func (s *RedisIdempotencyStore) Close() {
+	s.cli.close()
+}
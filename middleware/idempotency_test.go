@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestIdempotency(t *testing.T) {
+	redis, err := NewRedisIdempotencyStore(&RedisConfig{Addr: fakeRedis(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close()
+	for name, store := range map[string]IdempotencyStore{"memory": NewMemoryIdempotencyStore(), "redis": redis} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				calls   atomic.Int32
+				release = make(chan struct{})
+				entered = make(chan struct{})
+			)
+			g := gin.New()
+			g.Use(gin.CustomRecovery(func(c *gin.Context, _ any) { c.AbortWithStatus(http.StatusInternalServerError) }))
+			g.Use(Idempotency(&IdempotencyConfig{Store: store, Required: true, MaxBodySize: 1024}))
+			g.POST("/pay", func(c *gin.Context) {
+				n := calls.Add(1)
+				c.Header("X-Order", "o-1")
+				c.JSON(http.StatusCreated, gin.H{"code": 200, "call": n})
+			})
+			g.POST("/slow", func(c *gin.Context) {
+				close(entered)
+				<-release
+				c.String(http.StatusOK, "done")
+			})
+			g.POST("/fail", func(c *gin.Context) {
+				if calls.Add(1)%2 == 1 {
+					c.JSON(http.StatusOK, gin.H{"code": 500, "message": "server error"})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"code": 200})
+			})
+			g.POST("/large", func(c *gin.Context) {
+				calls.Add(1)
+				c.String(http.StatusOK, strings.Repeat("x", 2048))
+			})
+			g.POST("/panic", func(c *gin.Context) {
+				if calls.Add(1) == 1 {
+					panic("boom")
+				}
+				c.String(http.StatusOK, "recovered")
+			})
+			post := func(path, key, body string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+				if key != "" {
+					r.Header.Set("Idempotency-Key", key)
+				}
+				g.ServeHTTP(w, r)
+				return w
+			}
+
+			first := post("/pay", "k1", `{"amount":1}`)
+			second := post("/pay", "k1", `{"amount":1}`)
+			if calls.Load() != 1 || second.Code != http.StatusCreated || second.Body.String() != first.Body.String() ||
+				second.Header().Get("X-Order") != "o-1" || second.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Fatalf("replay = %d %s %v, calls %d", second.Code, second.Body.String(), second.Header(), calls.Load())
+			}
+			if w := post("/pay", "k1", `{"amount":2}`); w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("different body = %d %s", w.Code, w.Body.String())
+			}
+			if w := post("/pay", "", `{}`); w.Code != http.StatusBadRequest {
+				t.Fatalf("missing key = %d", w.Code)
+			}
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- post("/slow", "k2", "") }()
+			<-entered
+			if w := post("/slow", "k2", ""); w.Code != http.StatusConflict {
+				t.Fatalf("in-flight duplicate = %d %s", w.Code, w.Body.String())
+			}
+			close(release)
+			if w := <-done; w.Body.String() != "done" {
+				t.Fatalf("slow = %s", w.Body.String())
+			}
+			if w := post("/slow", "k2", ""); w.Body.String() != "done" || w.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Fatalf("slow replay = %s", w.Body.String())
+			}
+
+			// 服务端错误和 panic 后允许重试
+			calls.Store(0)
+			post("/fail", "k3", "")
+			if w := post("/fail", "k3", ""); w.Body.String() != `{"code":200}` || calls.Load() != 2 {
+				t.Fatalf("retry after server error = %s, calls %d", w.Body.String(), calls.Load())
+			}
+			calls.Store(0)
+			if w := post("/panic", "k4", ""); w.Code != http.StatusInternalServerError {
+				t.Fatalf("panic = %d", w.Code)
+			}
+			if w := post("/panic", "k4", ""); w.Body.String() != "recovered" {
+				t.Fatalf("retry after panic = %s", w.Body.String())
+			}
+
+			// 响应超过 MaxBodySize 时只记录已完成，重复请求不再执行
+			calls.Store(0)
+			if w := post("/large", "k5", ""); w.Body.Len() != 2048 {
+				t.Fatalf("large = %d", w.Body.Len())
+			}
+			if w := post("/large", "k5", ""); w.Code != http.StatusConflict || calls.Load() != 1 {
+				t.Fatalf("large duplicate = %d %s, calls %d", w.Code, w.Body.String(), calls.Load())
+			}
+
+			// Save、Delete 只对持有 token 的记录生效
+			ctx := context.Background()
+			if _, ok, err := store.Lock(ctx, "owned", &IdempotencyRecord{Token: "t1"}, time.Minute); !ok || err != nil {
+				t.Fatalf("Lock() = %v, %v", ok, err)
+			}
+			if err := store.Save(ctx, "owned", &IdempotencyRecord{Token: "t2", Completed: true}, time.Minute); !errors.Is(err, ErrIdempotencyLockLost) {
+				t.Fatalf("Save() other token err = %v", err)
+			}
+			_ = store.Delete(ctx, "owned", "t2")
+			if existing, ok, _ := store.Lock(ctx, "owned", &IdempotencyRecord{Token: "t3"}, time.Minute); ok || existing.Token != "t1" {
+				t.Fatal("Delete() removed record of other token")
+			}
+			_ = store.Delete(ctx, "owned", "t1")
+			if _, ok, _ := store.Lock(ctx, "owned", &IdempotencyRecord{Token: "t3"}, time.Minute); !ok {
+				t.Fatal("Delete() owner token not removed")
+			}
+		})
+	}
+}
+
+func TestIdempotencyLockRenew(t *testing.T) {
+	var calls atomic.Int32
+	release, entered := make(chan struct{}), make(chan struct{})
+	g := gin.New()
+	g.Use(Idempotency(&IdempotencyConfig{LockTTL: xtime.Duration(30 * time.Millisecond)}))
+	g.POST("/slow", func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		c.String(http.StatusOK, "done")
+	})
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/slow", nil)
+		r.Header.Set("Idempotency-Key", "k")
+		g.ServeHTTP(w, r)
+		return w
+	}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post() }()
+	<-entered
+	// 处理时间超过 LockTTL 时锁被续期
+	time.Sleep(100 * time.Millisecond)
+	if w := post(); w.Code != http.StatusConflict || calls.Load() != 1 {
+		t.Fatalf("duplicate after lock ttl = %d, calls %d", w.Code, calls.Load())
+	}
+	close(release)
+	<-done
+	if w := post(); w.Header().Get(IdempotentReplayedHeader) != "true" || calls.Load() != 1 {
+		t.Fatalf("replay = %s, calls %d", w.Body.String(), calls.Load())
+	}
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var IPForbiddenErr = ecode.New(http.StatusForbidden, "IP_FORBIDDEN", "ip forbidden")
+
+type IPFilterConfig struct {
+	Allow    []string       `json:"allow" yaml:"allow" toml:"allow"`          // 允许的 IP 或 CIDR，非空时仅允许名单内的 IP
+	Deny     []string       `json:"deny" yaml:"deny" toml:"deny"`             // 拒绝的 IP 或 CIDR，优先于 Allow
+	File     string         `json:"file" yaml:"file" toml:"file"`             // json、yaml、toml 名单文件，格式同 {allow: [], deny: []}，与 Allow、Deny 合并
+	Interval xtime.Duration `json:"interval" yaml:"interval" toml:"interval"` // 名单文件变化检查间隔，default 5s
+
+	// 可信代理的 IP 或 CIDR，仅当连接来自可信代理时使用 X-Forwarded-For、X-Real-Ip 获取客户端 IP，为空时只使用连接地址
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// IPFilter 按 IP 名单过滤请求，客户端 IP 见 IPFilter.ClientIP
+type IPFilter struct {
+	conf    *IPFilterConfig
+	trusted *ipTree
+	lists   atomic.Pointer[ipLists]
+	cancel  context.CancelFunc
+}
+
+type ipLists struct {
+	allow *ipTree
+	deny  *ipTree
+}
+
+type ipListFile struct {
+	Allow []string `json:"allow" yaml:"allow" toml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny" toml:"deny"`
+}
+
+// NewIPFilter 配置了 File 时加载名单文件，并按 Interval 检查文件变化后重新加载，重新加载失败时保留当前名单
+func NewIPFilter(conf *IPFilterConfig) (*IPFilter, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("ip filter config is nil")
+	}
+	trusted, err := newIPTree(conf.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("ip filter trusted_proxies: %w", err)
+	}
+	f := &IPFilter{conf: conf, trusted: trusted}
+	if conf.File == "" {
+		return f, f.Load(nil, nil)
+	}
+	if err := f.LoadFile(conf.File); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	go f.watch(ctx, conf.File, durationOr(conf.Interval, 5*time.Second))
+	return f, nil
+}
+
+// IPFilterWithConfig gin middleware，配置错误时 panic，按路由组分别注册，例如 g.Group("/admin", IPFilterWithConfig(conf))
+func IPFilterWithConfig(conf *IPFilterConfig) gin.HandlerFunc {
+	f, err := NewIPFilter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return f.Handler()
+}
+
+func (f *IPFilter) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ip := f.ClientIP(c.Request); !f.Allowed(ip) {
+			xlog.Warnf("ip filter: forbidden ip(%s) %s %s", ip, c.Request.Method, c.Request.URL.Path)
+			writeErrorStatus(c.Writer, http.StatusForbidden, IPForbiddenErr)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ClientIP 连接来自可信代理时，从右向左取 X-Forwarded-For 中第一个非可信代理的地址，没有 X-Forwarded-For 时使用 metadata.ClientIP
+// 连接不是来自可信代理时使用连接地址，防止客户端伪造请求头绕过名单
+func (f *IPFilter) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		remote = strings.TrimSpace(r.RemoteAddr)
+	}
+	if !f.isTrusted(remote) {
+		return remote
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hop := strings.TrimSpace(hops[i]); i == 0 || !f.isTrusted(hop) {
+			return hop
+		}
+	}
+	return metadata.ClientIP(r, r.Header)
+}
+
+func (f *IPFilter) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && f.trusted.contains(addr.Unmap().WithZone(""))
+}
+
+// Allowed 命中 Deny 时拒绝，Allow 非空时仅允许命中 Allow 的 IP，无法解析的 IP 总是拒绝
+func (f *IPFilter) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	l := f.lists.Load()
+	if l.deny.contains(addr) {
+		return false
+	}
+	return l.allow.size == 0 || l.allow.contains(addr)
+}
+
+// Load 替换名单，与 IPFilterConfig 的 Allow、Deny 合并
+func (f *IPFilter) Load(allow, deny []string) error {
+	a, err := newIPTree(append(append([]string(nil), f.conf.Allow...), allow...))
+	if err != nil {
+		return fmt.Errorf("ip filter allow: %w", err)
+	}
+	d, err := newIPTree(append(append([]string(nil), f.conf.Deny...), deny...))
+	if err != nil {
+		return fmt.Errorf("ip filter deny: %w", err)
+	}
+	f.lists.Store(&ipLists{allow: a, deny: d})
+	return nil
+}
+
+// LoadFile 按扩展名解析 json、yaml、toml 名单文件并加载
+func (f *IPFilter) LoadFile(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	list := &ipListFile{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(bs, list)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bs, list)
+	case ".toml":
+		err = toml.Unmarshal(bs, list)
+	default:
+		return fmt.Errorf("unsupported ip filter file(%s)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse ip filter file(%s) error: %w", path, err)
+	}
+	return f.Load(list.Allow, list.Deny)
+}
+
+// Close 停止名单文件监听
+func (f *IPFilter) Close() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+}
+
+func (f *IPFilter) watch(ctx context.Context, path string, interval time.Duration) {
+	stat, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		st, err := os.Stat(path)
+		if err != nil || (stat != nil && st.ModTime().Equal(stat.ModTime()) && st.Size() == stat.Size()) {
+			continue
+		}
+		stat = st
+		if err = f.LoadFile(path); err != nil {
+			xlog.Errorf("ip filter reload %s error: %v", path, err)
+			continue
+		}
+		xlog.Warnf("ip filter reload %s success", path)
+	}
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestIPTree(t *testing.T) {
+	tree, err := newIPTree([]string{"10.0.0.0/8", "10.1.0.0/16", "192.168.1.7", "172.16.0.0/12", "2001:db8::/32", "::ffff:100.64.0.0/106", "# comment", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.200.0.1":      true,
+		"10.1.2.3":        true,
+		"11.0.0.1":        false,
+		"192.168.1.7":     true,
+		"192.168.1.8":     false,
+		"172.31.255.255":  true,
+		"172.32.0.0":      false,
+		"2001:db8:1::1":   true,
+		"2001:db9::1":     false,
+		"::ffff:10.0.0.1": true,
+		"100.64.1.1":      true,
+		"::a00:1":         false, // IPv4-compatible 地址不等同于 IPv4
+	}
+	for ip, want := range cases {
+		if got := tree.contains(netip.MustParseAddr(ip).Unmap()); got != want {
+			t.Errorf("contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if _, err = newIPTree([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("invalid cidr should fail")
+	}
+}
+
+func TestIPTreeRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var (
+		list     []string
+		prefixes []netip.Prefix
+	)
+	for i := 0; i < 500; i++ {
+		addr := netip.AddrFrom4([4]byte{byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))})
+		p := netip.PrefixFrom(addr, 8+r.Intn(25)).Masked()
+		list = append(list, p.String())
+		prefixes = append(prefixes, p)
+	}
+	tree, err := newIPTree(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5000; i++ {
+		addr := netip.AddrFrom4([4]byte{byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))})
+		want := false
+		for _, p := range prefixes {
+			if p.Contains(addr) {
+				want = true
+				break
+			}
+		}
+		if got := tree.contains(addr); got != want {
+			t.Fatalf("contains(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ips.yaml")
+	if err := os.WriteFile(path, []byte("allow:\n  - 10.0.0.0/8\ndeny:\n  - 10.0.0.66\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewIPFilter(&IPFilterConfig{Allow: []string{"2001:db8::/32"}, File: path, Interval: xtime.Duration(10 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g := gin.New()
+	g.Use(f.Handler())
+	g.GET("/admin", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	do := func(remote, xff string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = remote
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		g.ServeHTTP(w, req)
+		return w.Code
+	}
+	cases := []struct {
+		remote, xff string
+		want        int
+	}{
+		{"10.1.1.1:1234", "", http.StatusOK},
+		{"[2001:db8::1]:1234", "", http.StatusOK},
+		{"8.8.8.8:1234", "", http.StatusForbidden},
+		{"10.0.0.66:1234", "", http.StatusForbidden},
+		{"8.8.8.8:1234", "10.2.2.2", http.StatusForbidden}, // 未配置可信代理时忽略伪造的 X-Forwarded-For
+		{"10.1.1.1:1234", "8.8.8.8", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if got := do(tc.remote, tc.xff); got != tc.want {
+			t.Errorf("remote %s xff %q: status = %d, want %d", tc.remote, tc.xff, got, tc.want)
+		}
+	}
+
+	// 文件变化后重新加载，解析失败时保留当前名单
+	if err = os.WriteFile(path, []byte("allow: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := do("10.1.1.1:1234", ""); got != http.StatusOK {
+		t.Fatalf("after bad reload: status = %d, want 200", got)
+	}
+	if err = os.WriteFile(path, []byte("allow:\n  - 8.8.8.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for do("8.8.8.8:1234", "") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("ip filter not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := do("10.1.1.1:1234", ""); got != http.StatusForbidden {
+		t.Fatalf("after reload: status = %d, want 403", got)
+	}
+}
+
+func TestIPFilterTrustedProxies(t *testing.T) {
+	f, err := NewIPFilter(&IPFilterConfig{Allow: []string{"10.0.0.0/8"}, TrustedProxies: []string{"192.168.0.0/16"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gin.New()
+	g.Use(f.Handler())
+	g.GET("/admin", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	cases := []struct {
+		remote, xff, realIP string
+		want                int
+	}{
+		{"192.168.1.1:1234", "10.2.2.2", "", http.StatusOK},
+		{"192.168.1.1:1234", "10.2.2.2, 192.168.1.2", "", http.StatusOK},
+		{"192.168.1.1:1234", "", "10.2.2.2", http.StatusOK},
+		// 客户端伪造的 X-Forwarded-For 在左侧，取最右侧的非可信代理地址
+		{"192.168.1.1:1234", "10.2.2.2, 8.8.8.8", "", http.StatusForbidden},
+		// 连接不是来自可信代理时忽略请求头
+		{"8.8.8.8:1234", "10.2.2.2", "10.2.2.2", http.StatusForbidden},
+		{"10.1.1.1:1234", "8.8.8.8", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-Ip", tc.realIP)
+		}
+		g.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("remote %s xff %q real ip %q: status = %d, want %d", tc.remote, tc.xff, tc.realIP, w.Code, tc.want)
+		}
+	}
+	if _, err = NewIPFilter(&IPFilterConfig{TrustedProxies: []string{"bad"}}); err == nil {
+		t.Fatal("invalid trusted proxy should fail")
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ipTree 路径压缩的二进制前缀树，IPv4 按 IPv4-mapped IPv6 存储，查询耗时与名单长度无关
+type ipTree struct {
+	root *ipNode
+	size int
+}
+
+type ipNode struct {
+	key      [16]byte
+	bits     int
+	leaf     bool // 名单中的前缀，子节点已被覆盖
+	children [2]*ipNode
+}
+
+// parseIPPrefix 解析 IP 或 CIDR，例如 10.0.0.1、10.0.0.0/8、2001:db8::/32
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() {
+			return netip.PrefixFrom(p.Addr().Unmap(), max(p.Bits()-96, 0)).Masked(), nil
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func newIPTree(list []string) (*ipTree, error) {
+	t := &ipTree{}
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		p, err := parseIPPrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip or cidr(%s): %w", s, err)
+		}
+		bits := p.Bits()
+		if p.Addr().Is4() {
+			bits += 96
+		}
+		t.insert(p.Addr().As16(), bits)
+	}
+	return t, nil
+}
+
+func (t *ipTree) insert(key [16]byte, bits int) {
+	t.size++
+	np := &t.root
+	for {
+		n := *np
+		if n == nil {
+			*np = &ipNode{key: key, bits: bits, leaf: true}
+			return
+		}
+		common := commonBits(n.key, key, min(n.bits, bits))
+		if common < n.bits {
+			// n 不在新前缀下，分裂出公共前缀节点
+			parent := &ipNode{key: maskBits(key, common), bits: common, leaf: common == bits}
+			parent.children[bitAt(n.key, common)] = n
+			if !parent.leaf {
+				parent.children[bitAt(key, common)] = &ipNode{key: key, bits: bits, leaf: true}
+			}
+			*np = parent
+			return
+		}
+		if n.leaf {
+			// 已被更短的前缀覆盖
+			return
+		}
+		if n.bits == bits {
+			n.leaf = true
+			return
+		}
+		np = &n.children[bitAt(key, n.bits)]
+	}
+}
+
+func (t *ipTree) contains(addr netip.Addr) bool {
+	key := addr.As16()
+	for n := t.root; n != nil; {
+		if commonBits(n.key, key, n.bits) < n.bits {
+			return false
+		}
+		if n.leaf {
+			return true
+		}
+		n = n.children[bitAt(key, n.bits)]
+	}
+	return false
+}
+
+func commonBits(a, b [16]byte, limit int) int {
+	n := 0
+	for i := 0; i < 16 && n < limit; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return min(n, limit)
+}
+
+func bitAt(key [16]byte, i int) int {
+	return int(key[i/8]>>(7-i%8)) & 1
+}
+
+func maskBits(key [16]byte, bits int) [16]byte {
+	for i := range key {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			key[i] &= ^byte(0xff >> bits)
+			bits = 0
+		default:
+			key[i] = 0
+		}
+	}
+	return key
+}
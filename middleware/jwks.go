@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pay/xlog"
+)
+
+const (
+	// jwksMinRefreshInterval 未知 kid 触发刷新的最小间隔，防止伪造 kid 打满 JWKS 服务
+	jwksMinRefreshInterval = 10 * time.Second
+	// jwksRetryInterval 刷新失败后的重试间隔
+	jwksRetryInterval = 5 * time.Second
+	jwksFetchTimeout  = 5 * time.Second
+)
+
+// jwksCache JWKS 密钥缓存，过期或遇到未知 kid 时刷新，刷新失败时继续使用旧密钥
+// 同一时刻只有一个刷新请求，刷新期间不阻塞使用已缓存密钥的校验
+type jwksCache struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	state    atomic.Pointer[jwksState]
+	mu       sync.Mutex
+	inflight chan struct{} // 刷新中时不为 nil，刷新完成后关闭
+}
+
+type jwksState struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time // 最近一次刷新成功的时间
+	failedAt  time.Time // 最近一次刷新失败的时间
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWKSCache(url string, client *http.Client, refresh time.Duration) *jwksCache {
+	j := &jwksCache{url: url, client: client, refresh: refresh}
+	j.state.Store(&jwksState{})
+	return j
+}
+
+func (j *jwksCache) get(ctx context.Context, kid string) crypto.PublicKey {
+	st := j.current(ctx)
+	if key, ok := st.keys[kid]; ok {
+		return key
+	}
+	// 密钥轮换后新 kid 尚未缓存
+	if time.Since(st.fetchedAt) >= jwksMinRefreshInterval {
+		st = j.fetch(ctx, true)
+	}
+	return st.keys[kid]
+}
+
+func (j *jwksCache) all(ctx context.Context) []crypto.PublicKey {
+	st := j.current(ctx)
+	keys := make([]crypto.PublicKey, 0, len(st.keys))
+	for _, key := range st.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// current 尚无密钥时等待首次刷新，已过期时后台刷新并先使用旧密钥
+func (j *jwksCache) current(ctx context.Context) *jwksState {
+	st := j.state.Load()
+	switch {
+	case len(st.keys) == 0:
+		return j.fetch(ctx, true)
+	case time.Since(st.fetchedAt) >= j.refresh:
+		j.fetch(ctx, false)
+	}
+	return st
+}
+
+// fetch 发起或复用进行中的刷新，wait 为 true 时等待刷新完成或 ctx 结束
+func (j *jwksCache) fetch(ctx context.Context, wait bool) *jwksState {
+	j.mu.Lock()
+	st := j.state.Load()
+	if j.inflight == nil && time.Since(st.failedAt) < jwksRetryInterval {
+		j.mu.Unlock()
+		return st
+	}
+	done := j.inflight
+	if done == nil {
+		done = make(chan struct{})
+		j.inflight = done
+		go j.load(done)
+	}
+	j.mu.Unlock()
+	if !wait {
+		return st
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return j.state.Load()
+}
+
+// load 使用独立的 context，客户端断开不影响刷新
+func (j *jwksCache) load(done chan struct{}) {
+	keys, err := j.request()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	old := j.state.Load()
+	if err != nil {
+		xlog.Errorf("jwks fetch %s error: %v", j.url, err)
+		j.state.Store(&jwksState{keys: old.keys, fetchedAt: old.fetchedAt, failedAt: time.Now()})
+	} else {
+		j.state.Store(&jwksState{keys: keys, fetchedAt: time.Now()})
+	}
+	j.inflight = nil
+	close(done)
+}
+
+func (j *jwksCache) request() (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks response status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			xlog.Warnf("jwks %s skip key(%s): %v", j.url, k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable key")
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve(%s)", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve(%s)", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported kty(%s)", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
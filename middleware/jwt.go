@@ -0,0 +1,418 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xtime"
+)
+
+const (
+	jwtClaimsKey     = "web/jwt_claims"
+	jwtRegisteredKey = "web/jwt_registered_claims"
+)
+
+var (
+	TokenMissingErr = ecode.New(http.StatusUnauthorized, "TOKEN_MISSING", "token missing")
+	TokenInvalidErr = ecode.New(http.StatusUnauthorized, "TOKEN_INVALID", "token invalid")
+	TokenExpiredErr = ecode.New(http.StatusUnauthorized, "TOKEN_EXPIRED", "token expired")
+
+	jwtHashes = map[string]crypto.Hash{
+		"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+		"EdDSA": 0,
+	}
+	jwtCurves = map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}
+)
+
+type JWTConfig struct {
+	Algorithms  []string          `json:"algorithms" yaml:"algorithms" toml:"algorithms"`       // 允许的算法，为空允许全部（HS、RS、PS、ES、EdDSA），none 始终拒绝
+	Secret      string            `json:"secret" yaml:"secret" toml:"secret"`                   // HS 算法密钥
+	PublicKeys  map[string]string `json:"public_keys" yaml:"public_keys" toml:"public_keys"`    // kid -> PEM 公钥或证书，用于 RS、PS、ES、EdDSA
+	JWKSURL     string            `json:"jwks_url" yaml:"jwks_url" toml:"jwks_url"`             // JWKS 地址，与 PublicKeys 可同时使用
+	JWKSRefresh xtime.Duration    `json:"jwks_refresh" yaml:"jwks_refresh" toml:"jwks_refresh"` // JWKS 缓存时长，default 5m，遇到未知 kid 时提前刷新（间隔至少 10s）
+	Issuer      string            `json:"issuer" yaml:"issuer" toml:"issuer"`                   // 不为空时校验 iss
+	Audience    []string          `json:"audience" yaml:"audience" toml:"audience"`             // 不为空时 aud 需包含其中之一
+	Leeway      xtime.Duration    `json:"leeway" yaml:"leeway" toml:"leeway"`                   // exp、nbf、iat 允许的时钟偏差
+	TokenLookup string            `json:"token_lookup" yaml:"token_lookup" toml:"token_lookup"` // 获取 token 的位置，按顺序查找，default header:Authorization，例如 header:Authorization,cookie:token,query:token
+	Client      *http.Client      `json:"-" yaml:"-" toml:"-"`                                  // 请求 JWKS 的 client，default 与 GinProxy 共用
+}
+
+// RegisteredClaims RFC 7519 注册的 claims
+type RegisteredClaims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  ClaimStrings `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+}
+
+// ClaimStrings aud 可以是字符串或字符串数组
+type ClaimStrings []string
+
+func (s *ClaimStrings) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = ClaimStrings{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+// NumericDate 秒级时间戳，兼容小数
+type NumericDate struct {
+	time.Time
+}
+
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return err
+	}
+	sec := int64(f)
+	d.Time = time.Unix(sec, int64((f-float64(sec))*1e9))
+	return nil
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprint(d.Unix())), nil
+}
+
+// JWTVerifier JWT 校验，静态密钥和 JWKS 密钥可同时使用
+type JWTVerifier struct {
+	conf       *JWTConfig
+	algorithms map[string]bool
+	secret     []byte
+	keys       map[string]crypto.PublicKey
+	jwks       *jwksCache
+	lookups    [][2]string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+func NewJWTVerifier(conf *JWTConfig) (*JWTVerifier, error) {
+	if conf == nil {
+		return nil, errors.New("jwt config is nil")
+	}
+	v := &JWTVerifier{conf: conf, secret: []byte(conf.Secret), keys: make(map[string]crypto.PublicKey)}
+	if len(conf.Algorithms) > 0 {
+		v.algorithms = make(map[string]bool)
+		for _, alg := range conf.Algorithms {
+			if _, ok := jwtHashes[alg]; !ok {
+				return nil, fmt.Errorf("unsupported jwt algorithm(%s)", alg)
+			}
+			v.algorithms[alg] = true
+		}
+	}
+	for kid, p := range conf.PublicKeys {
+		key, err := parsePublicKeyPEM(p)
+		if err != nil {
+			return nil, fmt.Errorf("jwt public key(%s) error: %w", kid, err)
+		}
+		v.keys[kid] = key
+	}
+	if conf.JWKSURL != "" {
+		cli := conf.Client
+		if cli == nil {
+			cli = httpCli
+		}
+		v.jwks = newJWKSCache(conf.JWKSURL, cli, durationOr(conf.JWKSRefresh, 5*time.Minute))
+	}
+	if len(v.secret) == 0 && len(v.keys) == 0 && v.jwks == nil {
+		return nil, errors.New("jwt secret, public_keys and jwks_url are all empty")
+	}
+	lookup := conf.TokenLookup
+	if lookup == "" {
+		lookup = "header:Authorization"
+	}
+	for _, l := range strings.Split(lookup, ",") {
+		src, name, ok := strings.Cut(strings.TrimSpace(l), ":")
+		if !ok || name == "" || (src != "header" && src != "cookie" && src != "query") {
+			return nil, fmt.Errorf("invalid jwt token_lookup(%s)", l)
+		}
+		v.lookups = append(v.lookups, [2]string{src, name})
+	}
+	return v, nil
+}
+
+// JWTAuth gin middleware，配置错误时 panic，运行时加载配置请使用 NewJWTVerifier
+// 校验通过后可通过 JWTClaims、JWTRegisteredClaims 获取 claims
+func JWTAuth(conf *JWTConfig) gin.HandlerFunc {
+	v, err := NewJWTVerifier(conf)
+	if err != nil {
+		panic(err)
+	}
+	return v.Handler()
+}
+
+func (v *JWTVerifier) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := v.extract(c)
+		if token == "" {
+			writeError(c.Writer, TokenMissingErr)
+			c.Abort()
+			return
+		}
+		claims, raw, err := v.Verify(c.Request.Context(), token)
+		if err != nil {
+			writeError(c.Writer, err)
+			c.Abort()
+			return
+		}
+		c.Set(jwtRegisteredKey, claims)
+		c.Set(jwtClaimsKey, raw)
+		c.Next()
+	}
+}
+
+// JWTClaims 将 token payload 解码为自定义 claims 类型
+func JWTClaims[T any](c *gin.Context) (claims T, ok bool) {
+	raw, ok := c.Get(jwtClaimsKey)
+	if !ok {
+		return claims, false
+	}
+	if err := json.Unmarshal(raw.([]byte), &claims); err != nil {
+		return claims, false
+	}
+	return claims, true
+}
+
+// JWTRegisteredClaims 已校验的注册 claims，未经过 JWTAuth 时返回 nil
+func JWTRegisteredClaims(c *gin.Context) *RegisteredClaims {
+	if v, ok := c.Get(jwtRegisteredKey); ok {
+		return v.(*RegisteredClaims)
+	}
+	return nil
+}
+
+func (v *JWTVerifier) extract(c *gin.Context) string {
+	for _, l := range v.lookups {
+		var token string
+		switch l[0] {
+		case "header":
+			token = c.GetHeader(l[1])
+			if strings.EqualFold(l[1], "Authorization") {
+				if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+					token = token[7:]
+				} else {
+					token = ""
+				}
+			}
+		case "cookie":
+			token, _ = c.Cookie(l[1])
+		case "query":
+			token = c.Query(l[1])
+		}
+		if token = strings.TrimSpace(token); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// Verify 校验签名和 iss、aud、exp、nbf，返回注册 claims 和原始 payload
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*RegisteredClaims, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, TokenInvalidErr
+	}
+	headerBs, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, TokenInvalidErr.WithCause(err)
+	}
+	header := &jwtHeader{}
+	if err = json.Unmarshal(headerBs, header); err != nil {
+		return nil, nil, TokenInvalidErr.WithCause(err)
+	}
+	if _, ok := jwtHashes[header.Alg]; !ok || (v.algorithms != nil && !v.algorithms[header.Alg]) {
+		return nil, nil, TokenInvalidErr.WithCause(fmt.Errorf("jwt algorithm(%s) not allowed", header.Alg))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, TokenInvalidErr.WithCause(err)
+	}
+	if err = v.verifySignature(ctx, header, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, nil, TokenInvalidErr.WithCause(err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, TokenInvalidErr.WithCause(err)
+	}
+	claims := &RegisteredClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, nil, TokenInvalidErr.WithCause(err)
+	}
+	if err = v.validate(claims); err != nil {
+		return nil, nil, err
+	}
+	return claims, payload, nil
+}
+
+func (v *JWTVerifier) validate(claims *RegisteredClaims) error {
+	now, leeway := time.Now(), time.Duration(v.conf.Leeway)
+	if claims.ExpiresAt != nil && now.After(claims.ExpiresAt.Add(leeway)) {
+		return TokenExpiredErr
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(claims.NotBefore.Time) {
+		return TokenInvalidErr.WithCause(errors.New("token not valid yet"))
+	}
+	if claims.IssuedAt != nil && now.Add(leeway).Before(claims.IssuedAt.Time) {
+		return TokenInvalidErr.WithCause(errors.New("token issued in the future"))
+	}
+	if v.conf.Issuer != "" && claims.Issuer != v.conf.Issuer {
+		return TokenInvalidErr.WithCause(fmt.Errorf("invalid issuer(%s)", claims.Issuer))
+	}
+	if len(v.conf.Audience) > 0 {
+		for _, want := range v.conf.Audience {
+			for _, aud := range claims.Audience {
+				if aud == want {
+					return nil
+				}
+			}
+		}
+		return TokenInvalidErr.WithCause(fmt.Errorf("invalid audience(%v)", claims.Audience))
+	}
+	return nil
+}
+
+func (v *JWTVerifier) verifySignature(ctx context.Context, header *jwtHeader, signed, sig []byte) error {
+	if strings.HasPrefix(header.Alg, "HS") {
+		if len(v.secret) == 0 {
+			return errors.New("hmac secret not configured")
+		}
+		mac := hmac.New(jwtHashes[header.Alg].New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	keys := v.candidateKeys(ctx, header.Kid)
+	if len(keys) == 0 {
+		return fmt.Errorf("no public key for kid(%s)", header.Kid)
+	}
+	var err error
+	for _, key := range keys {
+		if err = verifyAsymmetric(header.Alg, key, signed, sig); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// candidateKeys 有 kid 时精确匹配，JWKS 中找不到时刷新一次；无 kid 时尝试全部密钥
+func (v *JWTVerifier) candidateKeys(ctx context.Context, kid string) []crypto.PublicKey {
+	if kid != "" {
+		if key, ok := v.keys[kid]; ok {
+			return []crypto.PublicKey{key}
+		}
+		if v.jwks != nil {
+			if key := v.jwks.get(ctx, kid); key != nil {
+				return []crypto.PublicKey{key}
+			}
+		}
+		return nil
+	}
+	keys := make([]crypto.PublicKey, 0, len(v.keys))
+	for _, key := range v.keys {
+		keys = append(keys, key)
+	}
+	if v.jwks != nil {
+		keys = append(keys, v.jwks.all(ctx)...)
+	}
+	return keys
+}
+
+// verifyAsymmetric 校验算法与密钥类型匹配，防止算法混淆
+func verifyAsymmetric(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	hash := jwtHashes[alg]
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		}
+	case *ecdsa.PublicKey:
+		if jwtCurves[alg] != k.Curve {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid ecdsa signature length")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			break
+		}
+		if !ed25519.Verify(k, signed, sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("algorithm(%s) does not match key type %T", alg, key)
+}
+
+func parsePublicKeyPEM(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
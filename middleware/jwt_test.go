@@ -0,0 +1,292 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xtime"
+)
+
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var (
+		sig []byte
+		err error
+	)
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwtCode(t *testing.T, w *httptest.ResponseRecorder) (int, string) {
+	t.Helper()
+	var rsp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &rsp)
+	return rsp.Code, rsp.Message
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	ecPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	der, _ = x509.MarshalPKIXPublicKey(edKey.Public())
+	edPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	der = x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+	rsaPEM := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: der}))
+	secret := []byte("hs-secret")
+
+	type merchantClaims struct {
+		Sub        string `json:"sub"`
+		MerchantId string `json:"merchant_id"`
+	}
+	g := gin.New()
+	g.Use(JWTAuth(&JWTConfig{
+		Secret:      string(secret),
+		PublicKeys:  map[string]string{"ec": ecPEM, "ed": edPEM, "rsa": rsaPEM},
+		Issuer:      "pay",
+		Audience:    []string{"merchant-api"},
+		Leeway:      xtime.Duration(5 * time.Second),
+		TokenLookup: "header:Authorization,cookie:token,query:token",
+	}))
+	g.GET("/me", func(c *gin.Context) {
+		claims, ok := JWTClaims[merchantClaims](c)
+		if !ok || JWTRegisteredClaims(c).Subject != claims.Sub {
+			c.String(http.StatusInternalServerError, "claims")
+			return
+		}
+		c.String(http.StatusOK, claims.MerchantId)
+	})
+
+	now := time.Now().Unix()
+	valid := map[string]any{"iss": "pay", "aud": "merchant-api", "sub": "u-1", "merchant_id": "m-9", "exp": now + 60}
+	for name, tk := range map[string]string{
+		"HS256": signJWT(t, "HS256", "", secret, valid),
+		"RS256": signJWT(t, "RS256", "rsa", rsaKey, valid),
+		"ES256": signJWT(t, "ES256", "ec", ecKey, valid),
+		"EdDSA": signJWT(t, "EdDSA", "", edKey, valid),
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		r.Header.Set("Authorization", "Bearer "+tk)
+		g.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "m-9" {
+			t.Fatalf("%s = %d %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	tk := signJWT(t, "ES256", "ec", ecKey, valid)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/me?token="+tk, nil)
+	g.ServeHTTP(w, r)
+	if w.Body.String() != "m-9" {
+		t.Fatalf("query token = %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: tk})
+	g.ServeHTTP(w, r)
+	if w.Body.String() != "m-9" {
+		t.Fatalf("cookie token = %s", w.Body.String())
+	}
+
+	expired := map[string]any{"iss": "pay", "aud": []string{"merchant-api"}, "exp": now - 60}
+	withinLeeway := map[string]any{"iss": "pay", "aud": []string{"merchant-api"}, "exp": now - 2}
+	wrongAud := map[string]any{"iss": "pay", "aud": "other", "exp": now + 60}
+	header, _ := json.Marshal(map[string]string{"alg": "none"})
+	payload, _ := json.Marshal(valid)
+	none := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	tests := []struct {
+		name, token string
+		want        *ecode.Error
+	}{
+		{"missing", "", TokenMissingErr},
+		{"expired", signJWT(t, "HS256", "", secret, expired), TokenExpiredErr},
+		{"leeway", signJWT(t, "HS256", "", secret, withinLeeway), nil},
+		{"audience", signJWT(t, "HS256", "", secret, wrongAud), TokenInvalidErr},
+		{"alg none", none, TokenInvalidErr},
+		{"bad signature", signJWT(t, "HS256", "", []byte("other"), valid), TokenInvalidErr},
+		// 用 RSA 公钥 PEM 作为 HMAC 密钥伪造的 token
+		{"alg confusion", signJWT(t, "HS256", "rsa", []byte(rsaPEM), valid), TokenInvalidErr},
+		{"unknown kid", signJWT(t, "ES256", "nope", ecKey, valid), TokenInvalidErr},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		g.ServeHTTP(w, r)
+		code, msg := jwtCode(t, w)
+		if tt.want == nil {
+			if w.Code != http.StatusOK || code != 0 {
+				t.Errorf("%s = %d %s", tt.name, code, msg)
+			}
+			continue
+		}
+		if code != tt.want.Code() || msg != tt.want.Message() {
+			t.Errorf("%s = %d %s, want %s", tt.name, code, msg, tt.want.Message())
+		}
+	}
+}
+
+func TestJWTAuthJWKS(t *testing.T) {
+	keyA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyB, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaJWK := func(kid string, k *rsa.PublicKey) map[string]string {
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())}
+	}
+	ecJWK := map[string]string{"kty": "EC", "kid": "b", "crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(keyB.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(keyB.Y.FillBytes(make([]byte, 32)))}
+	var (
+		rotated atomic.Bool
+		failing atomic.Bool
+		fetches atomic.Int32
+	)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		keys := []any{rsaJWK("a", &keyA.PublicKey)}
+		if rotated.Load() {
+			keys = append(keys, ecJWK)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer jwks.Close()
+
+	v, err := NewJWTVerifier(&JWTConfig{JWKSURL: jwks.URL, Algorithms: []string{"RS256", "ES256"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]any{"sub": "u-1", "exp": time.Now().Unix() + 60}
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "RS256", "a", keyA, claims)); err != nil {
+		t.Fatalf("Verify() kid a = %v", err)
+	}
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "RS256", "a", keyA, claims)); err != nil || fetches.Load() != 1 {
+		t.Fatalf("Verify() cached = %v, fetches %d", err, fetches.Load())
+	}
+
+	// 密钥轮换：未知 kid 触发刷新
+	rotated.Store(true)
+	v.jwks.state.Store(&jwksState{keys: v.jwks.state.Load().keys, fetchedAt: time.Now().Add(-jwksMinRefreshInterval)})
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "ES256", "b", keyB, claims)); err != nil || fetches.Load() != 2 {
+		t.Fatalf("Verify() rotated kid b = %v, fetches %d", err, fetches.Load())
+	}
+	// 刷新间隔内的未知 kid 不再请求 JWKS
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "ES256", "c", keyB, claims)); err == nil || fetches.Load() != 2 {
+		t.Fatalf("Verify() unknown kid = %v, fetches %d", err, fetches.Load())
+	}
+	if !strings.Contains(err.Error(), "TOKEN_INVALID") {
+		t.Fatalf("Verify() unknown kid err = %v", err)
+	}
+
+	// 刷新失败时保留旧密钥，不推进 fetchedAt，重试间隔内不再请求
+	failing.Store(true)
+	stale := time.Now().Add(-jwksMinRefreshInterval)
+	v.jwks.state.Store(&jwksState{keys: v.jwks.state.Load().keys, fetchedAt: stale})
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "ES256", "d", keyB, claims)); err == nil || fetches.Load() != 3 {
+		t.Fatalf("Verify() refresh failed = %v, fetches %d", err, fetches.Load())
+	}
+	if st := v.jwks.state.Load(); !st.fetchedAt.Equal(stale) || st.failedAt.IsZero() || len(st.keys) != 2 {
+		t.Fatalf("state after failed refresh = %+v", st)
+	}
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "ES256", "d", keyB, claims)); err == nil || fetches.Load() != 3 {
+		t.Fatalf("Verify() within retry interval = %v, fetches %d", err, fetches.Load())
+	}
+	if _, _, err = v.Verify(context.Background(), signJWT(t, "ES256", "b", keyB, claims)); err != nil {
+		t.Fatalf("Verify() cached key after failed refresh = %v", err)
+	}
+}
+
+func TestJWKSDetachedFetch(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{map[string]string{"kty": "RSA", "kid": "a",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())}}})
+	}))
+	defer jwks.Close()
+	v, err := NewJWTVerifier(&JWTConfig{JWKSURL: jwks.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signJWT(t, "RS256", "a", key, map[string]any{"exp": time.Now().Unix() + 60})
+
+	// 客户端断开后刷新仍继续，并发请求共享同一次刷新
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := v.Verify(ctx, token)
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("Verify() with canceled ctx before jwks loaded should fail")
+		}
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for len(v.jwks.state.Load().keys) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, _, err = v.Verify(context.Background(), token); err != nil || fetches.Load() != 1 {
+		t.Fatalf("Verify() after detached fetch = %v, fetches %d", err, fetches.Load())
+	}
+}
@@ -1,13 +1,16 @@
 package middleware
 
 import (
-	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
 	"github.com/go-pay/limiter"
 )
 
+// ServerBusyErr Limiter、ConcurrencyLimit 拒绝请求时的响应
+var ServerBusyErr = ecode.New(503, "SERVER_BUSY", "服务器忙，请稍后重试...")
+
 // Limiter gin middleware limiter
 // if rl is nil, default Bucket = 1000, Rate = 1000
 func Limiter(appName string, rl *limiter.RateLimiter) gin.HandlerFunc {
@@ -22,13 +25,7 @@ func Limiter(appName string, rl *limiter.RateLimiter) gin.HandlerFunc {
 		// log.Warning("key:", path[1:])
 		l := rl.LimiterGroup.Get(limitKey)
 		if !l.Allow() {
-			c.JSON(http.StatusOK, struct {
-				Code    int    `json:"code"`
-				Message string `json:"message"`
-			}{
-				Code:    503,
-				Message: "服务器忙，请稍后重试...",
-			})
+			writeError(c.Writer, ServerBusyErr)
 			c.Abort()
 			return
 		}
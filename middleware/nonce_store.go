@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NonceStore 记录已使用的 nonce，用于防重放
+type NonceStore interface {
+	// Add key 不存在时记录并返回 true，已存在且未过期返回 false
+	Add(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonceStore 进程内存储，仅适用于单实例部署
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	items     map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{items: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+func (s *MemoryNonceStore) Add(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 定期清理过期 nonce
+	if now.Sub(s.lastSweep) >= time.Minute {
+		for k, deadline := range s.items {
+			if now.After(deadline) {
+				delete(s.items, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if deadline, ok := s.items[key]; ok && !now.After(deadline) {
+		return false, nil
+	}
+	s.items[key] = now.Add(ttl)
+	return true, nil
+}
+
+// RedisNonceStore 基于 Redis 协议的存储，多实例共享
+type RedisNonceStore struct {
+	cli    *redisClient
+	prefix string
+}
+
+// NewRedisNonceStore key 前缀为 web:nonce:
+func NewRedisNonceStore(conf *RedisConfig) (*RedisNonceStore, error) {
+	cli, err := newRedisClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisNonceStore{cli: cli, prefix: "web:nonce:"}, nil
+}
+
+func (s *RedisNonceStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	reply, err := s.cli.do(ctx, "SET", s.prefix+key, "1", "NX", "PX", redisTTL(ttl))
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// Close 关闭空闲连接
+func (s *RedisNonceStore) Close() {
+	s.cli.close()
+}
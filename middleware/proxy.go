@@ -1,118 +1,368 @@
 package middleware
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"net"
 	"net/http"
-	"reflect"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+	"github.com/go-pay/xlog"
 )
 
 var (
-	httpCli = &http.Client{
-		Timeout: 60 * time.Second,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: defaultTransportDialContext(&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}),
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			DisableKeepAlives:     true,
-			ForceAttemptHTTP2:     true,
-		},
-	}
+	// hop-by-hop 头，转发时去掉
+	hopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+		"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+	pureProxies = &pureProxyCache{ll: list.New(), items: make(map[pureProxyKey]*list.Element)}
+
+	// 默认 upstream client：校验证书、开启 keep-alive，通过 SetProxyClient 或 WithProxyClient 替换
+	httpCli, _ = NewProxyClient(nil)
 )
 
+type ProxyOption func(o *proxyOptions)
+
+type proxyOptions struct {
+	client   *http.Client
+	upstream *Upstream
+	retry    *RetryPolicy
+	breakers *BreakerGroup
+	timeout  time.Duration
+	decoder  ProxyDecoder
+}
+
+type pureProxyKey struct {
+	host     string
+	client   *http.Client
+	upstream *Upstream
+}
+
+// pureProxyMax GinPureProxy 缓存的 ReverseProxy 数量上限，host 按请求动态生成时淘汰最久未使用的
+const pureProxyMax = 256
+
+// pureProxyCache pureProxyKey -> *ReverseProxy 的 LRU 缓存
+type pureProxyCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[pureProxyKey]*list.Element
+}
+
+type pureProxyItem struct {
+	key   pureProxyKey
+	proxy *ReverseProxy
+}
+
+func (pc *pureProxyCache) get(key pureProxyKey) *ReverseProxy {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if el, ok := pc.items[key]; ok {
+		pc.ll.MoveToFront(el)
+		return el.Value.(*pureProxyItem).proxy
+	}
+	return nil
+}
+
+// add key 已存在时返回已缓存的 ReverseProxy
+func (pc *pureProxyCache) add(key pureProxyKey, p *ReverseProxy) *ReverseProxy {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if el, ok := pc.items[key]; ok {
+		pc.ll.MoveToFront(el)
+		return el.Value.(*pureProxyItem).proxy
+	}
+	pc.items[key] = pc.ll.PushFront(&pureProxyItem{key: key, proxy: p})
+	for pc.ll.Len() > pureProxyMax {
+		el := pc.ll.Back()
+		pc.ll.Remove(el)
+		delete(pc.items, el.Value.(*pureProxyItem).key)
+	}
+	return p
+}
+
+func (pc *pureProxyCache) len() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.ll.Len()
+}
+
+// proxyResult upstream 原始响应
+type proxyResult struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// WithProxyClient 指定本次代理使用的 http client，可通过 NewProxyClient 按 upstream 创建
+func WithProxyClient(cli *http.Client) ProxyOption {
+	return func(o *proxyOptions) {
+		if cli != nil {
+			o.client = cli
+		}
+	}
+}
+
+// WithUpstream 从 upstream 节点池中选择节点转发，此时忽略 host 参数
+func WithUpstream(u *Upstream) ProxyOption {
+	return func(o *proxyOptions) {
+		o.upstream = u
+	}
+}
+
+// WithRetry 失败重试，开启后请求体会被缓冲以便重放，p 需按路由复用以共享重试预算
+func WithRetry(p *RetryPolicy) ProxyOption {
+	return func(o *proxyOptions) {
+		o.retry = p
+	}
+}
+
+// WithCircuitBreaker 按 upstream host 熔断，熔断期间直接返回 BreakerOpenErr
+func WithCircuitBreaker(g *BreakerGroup) ProxyOption {
+	return func(o *proxyOptions) {
+		o.breakers = g
+	}
+}
+
+// WithProxyTimeout 本次代理的总超时（含重试），与客户端请求的 context 取先到者
+func WithProxyTimeout(d time.Duration) ProxyOption {
+	return func(o *proxyOptions) {
+		o.timeout = d
+	}
+}
+
+func newProxyOptions(opts []ProxyOption) *proxyOptions {
+	o := &proxyOptions{client: httpCli, decoder: EnvelopeDecoder}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
 type HttpRsp[V any] struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Data    V      `json:"data,omitempty"`
 }
 
-// GinProxy gin request proxy and get rsp
-func GinProxy[Rsp any](c *gin.Context, host, uri string) (rsp Rsp, err error) {
-	var (
-		rMethod = c.Request.Method
-		rHeader = c.Request.Header
-		rUri    = c.Request.RequestURI
-	)
-	vo := reflect.ValueOf(rsp)
-	if vo.Kind() != reflect.Ptr {
-		err = ecode.New(500, "", "rspParam must be point kind")
+// GinProxy gin request proxy and get rsp，default 按 HttpRsp 结构解码，通过 WithDecoder 修改
+func GinProxy[Rsp any](c *gin.Context, host, uri string, opts ...ProxyOption) (rsp Rsp, err error) {
+	res, err := GinProxyResult[Rsp](c, host, uri, opts...)
+	if err != nil {
 		return
 	}
-	if uri != "" {
-		rUri = uri
+	return res.Data, nil
+}
+
+// GinProxyResult 同 GinProxy，额外返回 upstream 响应状态和响应头，解码失败时 result 不为 nil
+func GinProxyResult[Rsp any](c *gin.Context, host, uri string, opts ...ProxyOption) (result *ProxyResult[Rsp], err error) {
+	o := newProxyOptions(opts)
+	if uri == "" {
+		uri = c.Request.RequestURI
 	}
-	uri = host + rUri
-	// Request
-	req, err := http.NewRequestWithContext(c, rMethod, uri, c.Request.Body)
+	res, err := o.do(c.Request, host, uri)
 	if err != nil {
-		return
+		return nil, err
+	}
+	result = &ProxyResult[Rsp]{StatusCode: res.StatusCode, Header: res.Header, Body: res.Body}
+	if err = o.decoder(res.StatusCode, res.Header, res.Body, &result.Data); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// do 按配置的超时、重试、熔断转发请求，返回最后一次尝试的结果
+func (o *proxyOptions) do(r *http.Request, host, uri string) (res *proxyResult, err error) {
+	var (
+		ctx    = r.Context()
+		policy = o.retry
+		header = proxyHeader(r.Header)
+		body   []byte
+	)
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	if policy != nil {
+		policy.init()
+		policy.budget.deposit()
+	}
+	if policy != nil && r.Body != nil {
+		// 首次请求会读完 Body，重试需要缓冲后重放
+		if body, err = metadata.RequestBody(r); err != nil {
+			return nil, ecode.RequestErr.WithCause(err)
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		var reqBody io.Reader = r.Body
+		if policy != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		res, err = o.try(ctx, r, header, host, uri, reqBody)
+		if policy == nil || attempt >= policy.MaxAttempts || !policy.retryable(r.Method) ||
+			!o.shouldRetry(ctx, policy, res, err) || !policy.budget.withdraw() || !policy.wait(ctx, attempt) {
+			break
+		}
+		lastErr := err
+		if lastErr == nil {
+			lastErr = fmt.Errorf("upstream response status %d", res.StatusCode)
+		}
+		xlog.Warnf("proxy %s %s retry attempt %d, last error: %v", r.Method, uri, attempt+1, lastErr)
 	}
-	// Request Header
-	req.Header = rHeader
-	// Do
-	resp, err := httpCli.Do(req)
 	if err != nil {
-		return
+		switch {
+		case errors.Is(r.Context().Err(), context.Canceled):
+			err = ClientClosedErr.WithCause(err)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+			// 包含最后一次尝试超过 RetryPolicy.PerTryTimeout
+			err = GatewayTimeoutErr.WithCause(err)
+		}
+	}
+	return res, err
+}
+
+// try 单次尝试：选择节点、熔断检查、单次超时
+func (o *proxyOptions) try(ctx context.Context, r *http.Request, header http.Header, host, uri string, body io.Reader) (*proxyResult, error) {
+	var backend *Backend
+	if o.upstream != nil {
+		b, err := o.upstream.Pick(r)
+		if err != nil {
+			return nil, err
+		}
+		backend, host = b, strings.TrimRight(b.URL.String(), "/")
+	}
+	breakerDone := func(BreakerResult) {}
+	if o.breakers != nil {
+		name := host
+		if u, err := url.Parse(host); err == nil && u.Host != "" {
+			name = u.Host
+		}
+		done, err := o.breakers.Allow(name)
+		if err != nil {
+			if backend != nil {
+				backend.release()
+			}
+			return nil, err
+		}
+		breakerDone = done
+	}
+	if o.retry != nil && o.retry.PerTryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(o.retry.PerTryTimeout))
+		defer cancel()
 	}
-	defer resp.Body.Close()
-	rspBytes, err := io.ReadAll(resp.Body)
+	req, err := http.NewRequestWithContext(ctx, r.Method, host+uri, body)
 	if err != nil {
-		return
+		if backend != nil {
+			backend.release()
+		}
+		breakerDone(BreakerIgnore)
+		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		err = ecode.New(resp.StatusCode, "", string(rspBytes))
-		return
+	req.Header = header.Clone()
+	propagateTimeout(ctx, req.Header)
+	resp, err := o.client.Do(req)
+	var res *proxyResult
+	if err == nil {
+		res = &proxyResult{StatusCode: resp.StatusCode, Header: resp.Header}
+		res.Body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
 	}
-	res := &HttpRsp[Rsp]{}
-	if err = json.Unmarshal(rspBytes, res); err != nil {
-		return
+	failure := upstreamError(resp, err)
+	result := BreakerSuccess
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		// 客户端主动取消不计入 upstream 失败，也不作为半开探测成功
+		failure, result = nil, BreakerIgnore
+	case failure != nil:
+		result = BreakerFailure
 	}
-	return res.Data, nil
+	if backend != nil {
+		backend.Done(failure)
+	}
+	breakerDone(result)
+	return res, err
 }
 
-// GinPureProxy gin request proxy
-func GinPureProxy(c *gin.Context, host string) {
-	var (
-		w       = c.Writer
-		r       = c.Request
-		rMethod = r.Method
-		rUri    = r.RequestURI
-	)
-	uri := host + rUri
-	// Request
-	req, err := http.NewRequestWithContext(c, rMethod, uri, c.Request.Body)
-	if err != nil {
-		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
-		return
+func (o *proxyOptions) shouldRetry(ctx context.Context, policy *RetryPolicy, res *proxyResult, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch {
+	case err == nil:
+		return policy.retryOnStatus(res.StatusCode)
+	case NoHealthyUpstreamErr.Is(err):
+		return false
+	case BreakerOpenErr.Is(err):
+		// 节点池中可能选到其他未熔断的节点
+		return o.upstream != nil
+	}
+	return true
+}
+
+// proxyHeader 复制请求头并去掉 hop-by-hop 头
+func proxyHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, f := range h.Values("Connection") {
+		for _, sf := range strings.Split(f, ",") {
+			if sf = textproto.TrimString(sf); sf != "" {
+				h.Del(sf)
+			}
+		}
 	}
-	// Request Header
-	req.Header = c.Request.Header
-	// Do
-	resp, err := httpCli.Do(req)
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+	return h
+}
+
+// upstreamError 网络错误和 5xx 响应计入节点失败
+func upstreamError(resp *http.Response, err error) error {
 	if err != nil {
-		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
-		return
+		return err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream response status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// GinPureProxy gin request proxy, host 为 upstream 地址，例如 http://127.0.0.1:8080
+// 需要路径重写、请求/响应修改等配置时使用 ReverseProxyHandler
+// 支持 WithProxyClient、WithUpstream、WithProxyTimeout、WithCircuitBreaker，响应以流式转发无法重放，不支持 WithRetry
+func GinPureProxy(c *gin.Context, host string, opts ...ProxyOption) {
+	o := newProxyOptions(opts)
+	if o.retry != nil {
+		xlog.Warnf("GinPureProxy(%s) does not support WithRetry, ignored", host)
 	}
-	defer resp.Body.Close()
-	// Response Header
-	for k, vs := range resp.Header {
-		for _, v := range vs {
-			w.Header().Add(k, v)
+	key := pureProxyKey{host: host, client: o.client, upstream: o.upstream}
+	p := pureProxies.get(key)
+	if p == nil {
+		rp, err := NewReverseProxy(&ProxyConfig{Target: host, Pool: o.upstream, Transport: o.client.Transport})
+		if err != nil {
+			xlog.Errorf("GinPureProxy(%s) error: %v", host, err)
+			writeError(c.Writer, ecode.BadGatewayErr)
+			c.Abort()
+			return
 		}
+		p = pureProxies.add(key, rp)
+	}
+	r := c.Request
+	if o.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), o.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	p.serve(c.Writer, r, o.breakers)
+	c.Abort()
 }
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-pay/xtime"
+)
+
+type ProxyClientConfig struct {
+	Timeout               xtime.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`                                                 // 单次请求总超时，default 60s，ReverseProxy 不使用
+	DialTimeout           xtime.Duration `json:"dial_timeout" yaml:"dial_timeout" toml:"dial_timeout"`                                  // default 5s
+	TLSHandshakeTimeout   xtime.Duration `json:"tls_handshake_timeout" yaml:"tls_handshake_timeout" toml:"tls_handshake_timeout"`       // default 10s
+	ResponseHeaderTimeout xtime.Duration `json:"response_header_timeout" yaml:"response_header_timeout" toml:"response_header_timeout"` // 等待响应头超时，0 不限制
+	IdleConnTimeout       xtime.Duration `json:"idle_conn_timeout" yaml:"idle_conn_timeout" toml:"idle_conn_timeout"`                   // default 90s
+	MaxIdleConns          int            `json:"max_idle_conns" yaml:"max_idle_conns" toml:"max_idle_conns"`                            // default 200
+	MaxIdleConnsPerHost   int            `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host" toml:"max_idle_conns_per_host"` // default 100
+	MaxConnsPerHost       int            `json:"max_conns_per_host" yaml:"max_conns_per_host" toml:"max_conns_per_host"`                // 0 不限制
+	DisableKeepAlives     bool           `json:"disable_keep_alives" yaml:"disable_keep_alives" toml:"disable_keep_alives"`
+	DisableHTTP2          bool           `json:"disable_http2" yaml:"disable_http2" toml:"disable_http2"`
+	CAFile                string         `json:"ca_file" yaml:"ca_file" toml:"ca_file"`                                        // 自定义 CA 证书（PEM），追加到系统 CA
+	CertFile              string         `json:"cert_file" yaml:"cert_file" toml:"cert_file"`                                  // 客户端证书（mTLS）
+	KeyFile               string         `json:"key_file" yaml:"key_file" toml:"key_file"`                                     // 客户端私钥（mTLS）
+	ServerName            string         `json:"server_name" yaml:"server_name" toml:"server_name"`                            // 校验证书使用的域名，default 请求 host
+	InsecureSkipVerify    bool           `json:"insecure_skip_verify" yaml:"insecure_skip_verify" toml:"insecure_skip_verify"` // 跳过证书校验，仅用于测试环境
+}
+
+// NewProxyClient 根据配置创建 upstream http client，conf 为空时使用默认配置：校验证书、开启 keep-alive
+func NewProxyClient(conf *ProxyClientConfig) (*http.Client, error) {
+	if conf == nil {
+		conf = &ProxyClientConfig{}
+	}
+	tlsConf, err := conf.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: defaultTransportDialContext(&net.Dialer{
+			Timeout:   durationOr(conf.DialTimeout, 5*time.Second),
+			KeepAlive: 30 * time.Second,
+		}),
+		TLSClientConfig:       tlsConf,
+		MaxIdleConns:          intOr(conf.MaxIdleConns, 200),
+		MaxIdleConnsPerHost:   intOr(conf.MaxIdleConnsPerHost, 100),
+		MaxConnsPerHost:       conf.MaxConnsPerHost,
+		IdleConnTimeout:       durationOr(conf.IdleConnTimeout, 90*time.Second),
+		TLSHandshakeTimeout:   durationOr(conf.TLSHandshakeTimeout, 10*time.Second),
+		ResponseHeaderTimeout: time.Duration(conf.ResponseHeaderTimeout),
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     conf.DisableKeepAlives,
+		ForceAttemptHTTP2:     !conf.DisableHTTP2,
+	}
+	if conf.DisableHTTP2 {
+		// 非 nil 的空 map 关闭 HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{
+		Timeout:   durationOr(conf.Timeout, 60*time.Second),
+		Transport: transport,
+	}, nil
+}
+
+// SetProxyClient 替换 GinProxy、GinPureProxy、ReverseProxy 默认使用的 http client，需在服务启动前调用
+func SetProxyClient(cli *http.Client) {
+	if cli != nil {
+		httpCli = cli
+	}
+}
+
+func (c *ProxyClientConfig) tlsConfig() (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_file(%s) error: %w", c.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file(%s) has no valid certificate", c.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConf, nil
+}
+
+func durationOr(d xtime.Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+func intOr(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+func stringOr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewProxyClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// 默认校验证书，自签名证书请求失败
+	cli, err := NewProxyClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.Get(srv.URL); err == nil {
+		t.Fatal("default client should verify certificate")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err = os.WriteFile(caFile, caPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cli, err = NewProxyClient(&ProxyClientConfig{CAFile: caFile, ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cli.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if _, err = NewProxyClient(&ProxyClientConfig{CertFile: "client.pem"}); err == nil {
+		t.Fatal("cert_file without key_file should fail")
+	}
+}
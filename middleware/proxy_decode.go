@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"github.com/go-pay/ecode"
+	"google.golang.org/protobuf/proto"
+)
+
+// upstream 错误响应体作为错误信息时的最大长度
+const maxErrorBodyLen = 1024
+
+// ProxyDecoder 将 upstream 响应解码到 v（*Rsp），返回的 error 作为 GinProxy 的 error 返回
+type ProxyDecoder func(statusCode int, header http.Header, body []byte, v any) error
+
+var (
+	// EnvelopeDecoder 解码 HttpRsp 结构，code 不等于 ecode.Success 时返回对应 ecode，GinProxy 默认使用
+	EnvelopeDecoder = NewEnvelopeDecoder()
+
+	// RawDecoder 按 Content-Type 将 2xx 响应体直接解码到 Rsp：xml、protobuf，其他按 json
+	RawDecoder ProxyDecoder = rawDecode
+)
+
+// ProxyResult upstream 响应状态、响应头、原始响应体及解码结果
+type ProxyResult[Rsp any] struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Data       Rsp
+}
+
+// WithDecoder 指定 GinProxy 解码 upstream 响应的方式，default EnvelopeDecoder
+func WithDecoder(d ProxyDecoder) ProxyOption {
+	return func(o *proxyOptions) {
+		if d != nil {
+			o.decoder = d
+		}
+	}
+}
+
+// NewEnvelopeDecoder successCodes 为业务成功码，default ecode.Success.Code()
+func NewEnvelopeDecoder(successCodes ...int) ProxyDecoder {
+	return func(statusCode int, header http.Header, body []byte, v any) error {
+		var env struct {
+			Code    *int            `json:"code"`
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(body, &env); err != nil || env.Code == nil {
+			// 非 HttpRsp 结构，例如网关返回的 502 页面
+			if statusCode/100 != 2 {
+				return statusError(statusCode, body)
+			}
+			if err == nil {
+				err = fmt.Errorf("upstream response has no code field")
+			}
+			return ecode.New(http.StatusBadGateway, "UPSTREAM_DECODE_ERROR", "upstream response decode error").WithCause(err)
+		}
+		if !isSuccessCode(*env.Code, successCodes) {
+			return ecode.New(*env.Code, "", env.Message)
+		}
+		if statusCode/100 != 2 {
+			return statusError(statusCode, body)
+		}
+		if len(env.Data) == 0 || bytes.Equal(env.Data, []byte("null")) {
+			return nil
+		}
+		if err := json.Unmarshal(env.Data, v); err != nil {
+			return ecode.New(http.StatusBadGateway, "UPSTREAM_DECODE_ERROR", "upstream response decode error").WithCause(err)
+		}
+		return nil
+	}
+}
+
+func isSuccessCode(code int, successCodes []int) bool {
+	if len(successCodes) == 0 {
+		return code == ecode.Success.Code()
+	}
+	for _, c := range successCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func rawDecode(statusCode int, header http.Header, body []byte, v any) (err error) {
+	if statusCode/100 != 2 {
+		return statusError(statusCode, body)
+	}
+	if len(body) == 0 || statusCode == http.StatusNoContent {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch mediaType {
+	case "application/xml", "text/xml":
+		err = xml.Unmarshal(body, v)
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		var m proto.Message
+		if m, err = protoMessage(v); err == nil {
+			err = proto.Unmarshal(body, m)
+		}
+	default:
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		return ecode.New(http.StatusBadGateway, "UPSTREAM_DECODE_ERROR", "upstream response decode error").WithCause(err)
+	}
+	return nil
+}
+
+// protoMessage v 为 *Rsp，Rsp 为 nil 的 proto 消息指针时先分配
+func protoMessage(v any) (proto.Message, error) {
+	if m, ok := v.(proto.Message); ok {
+		return m, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok := rv.Elem().Interface().(proto.Message); ok {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not proto.Message", reflect.TypeOf(v).Elem())
+}
+
+// statusError 非 2xx 响应转为 ecode，code 为 upstream 状态码
+func statusError(statusCode int, body []byte) error {
+	msg := string(bytes.TrimSpace(body))
+	if len(msg) > maxErrorBodyLen {
+		msg = msg[:maxErrorBodyLen]
+	}
+	if msg == "" {
+		msg = http.StatusText(statusCode)
+	}
+	return ecode.New(statusCode, "UPSTREAM_ERROR", msg)
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type decodeOrder struct {
+	Id string `json:"id" xml:"id"`
+}
+
+func TestGinProxyDecoder(t *testing.T) {
+	pb, _ := proto.Marshal(wrapperspb.String("pb-1"))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/envelope":
+			_, _ = w.Write([]byte(`{"code":200,"message":"success","data":{"id":"env-1"}}`))
+		case "/biz_error":
+			_, _ = w.Write([]byte(`{"code":10001,"message":"order not found"}`))
+		case "/raw":
+			w.Header().Set("X-Trace-Id", "trace-1")
+			_, _ = w.Write([]byte(`{"id":"raw-1"}`))
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<order><id>xml-1</id></order>`))
+		case "/pb":
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			_, _ = w.Write(pb)
+		default:
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+
+	run := func(fn func(c *gin.Context)) {
+		g := gin.New()
+		g.GET("/*path", fn)
+		g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	run(func(c *gin.Context) {
+		rsp, err := GinProxy[decodeOrder](c, upstream.URL, "/envelope")
+		if err != nil || rsp.Id != "env-1" {
+			t.Errorf("envelope = %+v, %v", rsp, err)
+		}
+		_, err = GinProxy[*decodeOrder](c, upstream.URL, "/biz_error")
+		if e := ecode.FromError(err); e.Code() != 10001 || e.Message() != "order not found" {
+			t.Errorf("biz_error err = %v", err)
+		}
+		res, err := GinProxyResult[*decodeOrder](c, upstream.URL, "/raw", WithDecoder(RawDecoder))
+		if err != nil || res.Data.Id != "raw-1" || res.Header.Get("X-Trace-Id") != "trace-1" {
+			t.Errorf("raw = %+v, %v", res, err)
+		}
+		xr, err := GinProxy[decodeOrder](c, upstream.URL, "/xml", WithDecoder(RawDecoder))
+		if err != nil || xr.Id != "xml-1" {
+			t.Errorf("xml = %+v, %v", xr, err)
+		}
+		pr, err := GinProxy[*wrapperspb.StringValue](c, upstream.URL, "/pb", WithDecoder(RawDecoder))
+		if err != nil || pr.GetValue() != "pb-1" {
+			t.Errorf("protobuf = %v, %v", pr, err)
+		}
+		res, err = GinProxyResult[*decodeOrder](c, upstream.URL, "/down", WithDecoder(RawDecoder))
+		if e := ecode.FromError(err); e.Code() != http.StatusBadGateway || res == nil || res.StatusCode != http.StatusBadGateway {
+			t.Errorf("non-2xx = %+v, %v", res, err)
+		}
+	})
+}
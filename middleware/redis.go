@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-pay/xtime"
+)
+
+type RedisConfig struct {
+	Addr         string         `json:"addr" yaml:"addr" toml:"addr"`                            // 例如 127.0.0.1:6379
+	Password     string         `json:"password" yaml:"password" toml:"password"`                // 为空不认证
+	DB           int            `json:"db" yaml:"db" toml:"db"`                                  // default 0
+	PoolSize     int            `json:"pool_size" yaml:"pool_size" toml:"pool_size"`             // 最大空闲连接数，default 10
+	DialTimeout  xtime.Duration `json:"dial_timeout" yaml:"dial_timeout" toml:"dial_timeout"`    // default 3s
+	ReadTimeout  xtime.Duration `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`    // 单条命令读写超时，default 1s
+	WriteTimeout xtime.Duration `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"` // default 同 ReadTimeout
+}
+
+// redisClient 最小化的 RESP 协议客户端，兼容 Redis、KeyDB、Dragonfly 等，仅用于中间件的存储
+type redisClient struct {
+	conf *RedisConfig
+	pool chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	wr   *bufio.Writer
+}
+
+// redisError 服务端返回的错误回复，连接仍可复用
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func newRedisClient(conf *RedisConfig) (*redisClient, error) {
+	if conf == nil || conf.Addr == "" {
+		return nil, errors.New("redis addr is empty")
+	}
+	return &redisClient{conf: conf, pool: make(chan *redisConn, intOr(conf.PoolSize, 10))}, nil
+}
+
+// do 执行命令，回复类型：string、int64、nil、[]any，错误回复返回 redisError
+func (c *redisClient) do(ctx context.Context, args ...any) (any, error) {
+	cn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(ctx, c.conf, args...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		// 网络错误时连接状态未知，直接关闭
+		_ = cn.conn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// redisTTL PX 参数，不足 1ms 按 1ms
+func redisTTL(ttl time.Duration) int64 {
+	if ms := ttl.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+// close 关闭空闲连接
+func (c *redisClient) close() {
+	for {
+		select {
+		case cn := <-c.pool:
+			_ = cn.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (c *redisClient) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+	d := &net.Dialer{Timeout: durationOr(c.conf.DialTimeout, 3*time.Second)}
+	conn, err := d.DialContext(ctx, "tcp", c.conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &redisConn{conn: conn, rd: bufio.NewReader(conn), wr: bufio.NewWriter(conn)}
+	if c.conf.Password != "" {
+		if _, err = cn.do(ctx, c.conf, "AUTH", c.conf.Password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if c.conf.DB != 0 {
+		if _, err = cn.do(ctx, c.conf, "SELECT", c.conf.DB); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *redisClient) put(cn *redisConn) {
+	select {
+	case c.pool <- cn:
+	default:
+		_ = cn.conn.Close()
+	}
+}
+
+func (cn *redisConn) do(ctx context.Context, conf *RedisConfig, args ...any) (any, error) {
+	readTimeout := durationOr(conf.ReadTimeout, time.Second)
+	deadline := time.Now().Add(readTimeout + durationOr(conf.WriteTimeout, readTimeout))
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := cn.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := cn.writeCommand(args); err != nil {
+		return nil, err
+	}
+	return cn.readReply()
+}
+
+func (cn *redisConn) writeCommand(args []any) error {
+	cn.wr.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		default:
+			return fmt.Errorf("redis: unsupported arg type %T", arg)
+		}
+		cn.wr.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+	}
+	return cn.wr.Flush()
+}
+
+func (cn *redisConn) readReply() (any, error) {
+	line, err := cn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(cn.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			var re redisError
+			if arr[i], err = cn.readReply(); err != nil && !errors.As(err, &re) {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (cn *redisConn) readLine() (string, error) {
+	line, err := cn.rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-pay/ecode"
+)
+
+// errorRsp 与 web.JSON 输出的结构保持一致
+type errorRsp struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError 按统一响应结构输出错误，HTTP 状态码与 web.JSON 一致为 200
+func writeError(w http.ResponseWriter, err error) {
+	writeErrorStatus(w, http.StatusOK, err)
+}
+
+// writeErrorStatus 按统一响应结构输出错误，用于 412 等客户端依赖 HTTP 状态码的场景
+func writeErrorStatus(w http.ResponseWriter, status int, err error) {
+	e := ecode.FromError(err)
+	bs, _ := json.Marshal(&errorRsp{Code: e.Code(), Message: e.Message()})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(bs)))
+	w.WriteHeader(status)
+	_, _ = w.Write(bs)
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-pay/xtime"
+)
+
+var defaultRetryOn = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+type RetryPolicy struct {
+	MaxAttempts        int            `json:"max_attempts" yaml:"max_attempts" toml:"max_attempts"`                            // 最大尝试次数（含首次），default 3
+	RetryOn            []int          `json:"retry_on" yaml:"retry_on" toml:"retry_on"`                                        // 需要重试的 upstream 状态码，default 502、503、504，网络错误总是重试
+	RetryNonIdempotent bool           `json:"retry_non_idempotent" yaml:"retry_non_idempotent" toml:"retry_non_idempotent"`    // 是否重试 POST、PATCH 等非幂等请求，default false
+	BaseDelay          xtime.Duration `json:"base_delay" yaml:"base_delay" toml:"base_delay"`                                  // 指数退避基础间隔，default 50ms
+	MaxDelay           xtime.Duration `json:"max_delay" yaml:"max_delay" toml:"max_delay"`                                     // 最大退避间隔，default 1s
+	PerTryTimeout      xtime.Duration `json:"per_try_timeout" yaml:"per_try_timeout" toml:"per_try_timeout"`                   // 单次尝试超时，0 不限制
+	BudgetRatio        float64        `json:"budget_ratio" yaml:"budget_ratio" toml:"budget_ratio"`                            // 重试预算：重试数占请求数的最大比例，default 0.2，小于 0 不限制
+	BudgetMinPerSecond int            `json:"budget_min_per_second" yaml:"budget_min_per_second" toml:"budget_min_per_second"` // 低流量时每秒至少允许的重试数，default 10
+
+	once   sync.Once
+	budget *retryBudget
+}
+
+func (p *RetryPolicy) init() {
+	p.once.Do(func() {
+		if p.MaxAttempts <= 0 {
+			p.MaxAttempts = 3
+		}
+		if len(p.RetryOn) == 0 {
+			p.RetryOn = defaultRetryOn
+		}
+		if p.BaseDelay <= 0 {
+			p.BaseDelay = xtime.Duration(50 * time.Millisecond)
+		}
+		if p.MaxDelay <= 0 {
+			p.MaxDelay = xtime.Duration(time.Second)
+		}
+		if p.BudgetRatio == 0 {
+			p.BudgetRatio = 0.2
+		}
+		if p.BudgetMinPerSecond <= 0 {
+			p.BudgetMinPerSecond = 10
+		}
+		if p.BudgetRatio > 0 {
+			p.budget = &retryBudget{ratio: p.BudgetRatio, minPerSecond: float64(p.BudgetMinPerSecond), tokens: float64(p.BudgetMinPerSecond), last: time.Now()}
+		}
+	})
+}
+
+// retryable 请求方法是否允许重试
+func (p *RetryPolicy) retryable(method string) bool {
+	if p.RetryNonIdempotent {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryOnStatus(status int) bool {
+	for _, s := range p.RetryOn {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 指数退避 + full jitter
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := time.Duration(p.BaseDelay) << (attempt - 1)
+	if d <= 0 || d > time.Duration(p.MaxDelay) {
+		d = time.Duration(p.MaxDelay)
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// wait 退避等待，ctx 结束时返回 false
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	t := time.NewTimer(p.backoff(attempt))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// retryBudget 令牌桶形式的重试预算：每个请求存入 ratio 个令牌，每秒补充 minPerSecond 个，每次重试消耗 1 个
+type retryBudget struct {
+	mu           sync.Mutex
+	ratio        float64
+	minPerSecond float64
+	tokens       float64
+	last         time.Time
+}
+
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens += b.ratio
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *retryBudget) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.minPerSecond
+	b.last = now
+	// 上限为 10 秒的最低重试量加上比例部分，避免长时间空闲后积累过多令牌
+	if max := b.minPerSecond*10 + b.ratio*1000; b.tokens > max {
+		b.tokens = max
+	}
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestGinProxyRetry(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// 前两次失败，第三次返回请求体，验证重试时请求体可重放
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":200,"data":{"body":"` + string(body) + `"}}`))
+	}))
+	defer upstream.Close()
+
+	policy := &RetryPolicy{BaseDelay: xtime.Duration(time.Millisecond)}
+	var (
+		rsp *struct {
+			Body string `json:"body"`
+		}
+		err error
+	)
+	g := gin.New()
+	g.Any("/retry", func(c *gin.Context) {
+		rsp, err = GinProxy[*struct {
+			Body string `json:"body"`
+		}](c, upstream.URL, "", WithRetry(policy))
+	})
+
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/retry", strings.NewReader("paid")))
+	if err != nil || rsp.Body != "paid" || calls.Load() != 3 {
+		t.Fatalf("GinProxy() = %+v, %v, calls %d", rsp, err, calls.Load())
+	}
+
+	// POST 默认不重试
+	calls.Store(0)
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/retry", strings.NewReader("paid")))
+	if err == nil || calls.Load() != 1 {
+		t.Fatalf("GinProxy() POST err = %v, calls %d", err, calls.Load())
+	}
+}
+
+func TestBreakerGroup(t *testing.T) {
+	changes := make(chan BreakerState, 4)
+	g := NewBreakerGroup(&BreakerConfig{
+		FailureThreshold: 2,
+		OpenTimeout:      xtime.Duration(20 * time.Millisecond),
+		OnStateChange: func(name string, from, to BreakerState) {
+			changes <- to
+		},
+	})
+	for i := 0; i < 2; i++ {
+		done, err := g.Allow("pay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(BreakerFailure)
+	}
+	if _, err := g.Allow("pay"); !BreakerOpenErr.Is(err) {
+		t.Fatalf("Allow() open err = %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	done, err := g.Allow("pay")
+	if err != nil || g.State("pay") != BreakerHalfOpen {
+		t.Fatalf("Allow() half-open err = %v, state %s", err, g.State("pay"))
+	}
+	if _, err = g.Allow("pay"); !BreakerOpenErr.Is(err) {
+		t.Fatalf("Allow() second probe err = %v", err)
+	}
+	// 没有结果的探测只归还名额，保持半开
+	done(BreakerIgnore)
+	if done, err = g.Allow("pay"); err != nil || g.State("pay") != BreakerHalfOpen {
+		t.Fatalf("Allow() after ignored probe err = %v, state %s", err, g.State("pay"))
+	}
+	done(BreakerSuccess)
+	if g.State("pay") != BreakerClosed {
+		t.Fatalf("State() = %s, want closed", g.State("pay"))
+	}
+	for _, want := range []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed} {
+		if got := <-changes; got != want {
+			t.Fatalf("OnStateChange to = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestGinProxyTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	var err error
+	g := gin.New()
+	g.GET("/slow", func(c *gin.Context) {
+		_, err = GinProxy[*struct{}](c, upstream.URL, "", WithProxyTimeout(20*time.Millisecond))
+	})
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if !GatewayTimeoutErr.Is(err) {
+		t.Fatalf("GinProxy() err = %v, want GatewayTimeoutErr", err)
+	}
+}
+
+func TestGinProxyBreakerClientCancel(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	breakers := NewBreakerGroup(&BreakerConfig{FailureThreshold: 1, OpenTimeout: xtime.Duration(10 * time.Millisecond)})
+	name := strings.TrimPrefix(upstream.URL, "http://")
+
+	g := gin.New()
+	g.GET("/pay", func(c *gin.Context) {
+		_, _ = GinProxy[*struct{}](c, upstream.URL, "", WithCircuitBreaker(breakers))
+	})
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/pay", nil))
+	if breakers.State(name) != BreakerOpen {
+		t.Fatalf("State() = %s, want open", breakers.State(name))
+	}
+
+	// 半开探测期间客户端取消，熔断器保持半开且归还探测名额
+	time.Sleep(20 * time.Millisecond)
+	fail.Store(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/pay", nil).WithContext(ctx))
+	if breakers.State(name) != BreakerHalfOpen {
+		t.Fatalf("State() after client cancel = %s, want half-open", breakers.State(name))
+	}
+	done, err := breakers.Allow(name)
+	if err != nil {
+		t.Fatalf("Allow() after client cancel err = %v", err)
+	}
+	done(BreakerIgnore)
+}
+
+func TestGinProxyPerTryTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	var err error
+	g := gin.New()
+	g.GET("/slow", func(c *gin.Context) {
+		_, err = GinProxy[*struct{}](c, upstream.URL, "", WithRetry(&RetryPolicy{
+			MaxAttempts:   2,
+			BaseDelay:     xtime.Duration(time.Millisecond),
+			PerTryTimeout: xtime.Duration(20 * time.Millisecond),
+		}))
+	})
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if !GatewayTimeoutErr.Is(err) {
+		t.Fatalf("GinProxy() err = %v, want GatewayTimeoutErr", err)
+	}
+}
@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+)
+
+var (
+	GatewayTimeoutErr = ecode.New(http.StatusGatewayTimeout, "GATEWAY_TIMEOUT", "upstream timeout")
+	ClientClosedErr   = ecode.New(ecode.ClientClosed, "CLIENT_CLOSED", "client closed request")
+)
+
+type ProxyConfig struct {
+	Target         string                          `json:"target" yaml:"target" toml:"target"`                            // upstream 地址，例如 http://127.0.0.1:8080/api，与 Upstream 二选一
+	Upstream       *UpstreamConfig                 `json:"upstream" yaml:"upstream" toml:"upstream"`                      // upstream 节点池配置
+	StripPrefix    string                          `json:"strip_prefix" yaml:"strip_prefix" toml:"strip_prefix"`          // 转发前去掉的路径前缀
+	Rewrite        []ProxyRewrite                  `json:"rewrite" yaml:"rewrite" toml:"rewrite"`                         // 路径重写规则，按顺序匹配第一条
+	PreserveHost   bool                            `json:"preserve_host" yaml:"preserve_host" toml:"preserve_host"`       // 保留客户端 Host 头，默认使用 upstream host
+	TrustForwarded bool                            `json:"trust_forwarded" yaml:"trust_forwarded" toml:"trust_forwarded"` // 信任并追加客户端传入的 X-Forwarded-For、Forwarded
+	FlushInterval  xtime.Duration                  `json:"flush_interval" yaml:"flush_interval" toml:"flush_interval"`    // 响应刷新间隔，负数每次写入立即刷新，SSE 自动立即刷新
+	Client         *ProxyClientConfig              `json:"client" yaml:"client" toml:"client"`                            // upstream client 配置，Transport 为空时生效
+	Transport      http.RoundTripper               `json:"-" yaml:"-" toml:"-"`                                           // default 与 GinProxy 共用的 Transport
+	Pool           *Upstream                       `json:"-" yaml:"-" toml:"-"`                                           // 已创建的 upstream 节点池，优先于 Upstream
+	ModifyRequest  func(req *http.Request)         `json:"-" yaml:"-" toml:"-"`                                           // 转发前修改请求
+	ModifyResponse func(resp *http.Response) error `json:"-" yaml:"-" toml:"-"`                                           // 返回客户端前修改响应，返回 error 时按 ErrorHandler 处理
+}
+
+type ProxyRewrite struct {
+	Match   string `json:"match" yaml:"match" toml:"match"`       // 正则
+	Replace string `json:"replace" yaml:"replace" toml:"replace"` // 替换，支持 $1 引用分组
+}
+
+type proxyRewrite struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// ReverseProxy 基于 httputil.ReverseProxy 的反向代理：
+// 去除 hop-by-hop 头、添加 X-Forwarded-* 和 Forwarded 头、支持路径重写、SSE 刷新和 WebSocket 透传，错误按统一响应结构输出
+type ReverseProxy struct {
+	conf      *ProxyConfig
+	target    *url.URL
+	pool      *Upstream
+	ownedPool bool
+	rewrites  []*proxyRewrite
+	proxy     *httputil.ReverseProxy
+}
+
+type proxyStateKey struct{}
+
+// proxyState 单次转发选中的节点和结果
+type proxyState struct {
+	backend *Backend
+	err     error
+}
+
+func NewReverseProxy(conf *ProxyConfig) (p *ReverseProxy, err error) {
+	if conf == nil {
+		return nil, errors.New("proxy config is nil")
+	}
+	p = &ReverseProxy{conf: conf, pool: conf.Pool}
+	switch {
+	case p.pool != nil:
+	case conf.Upstream != nil:
+		if p.pool, err = NewUpstream(conf.Upstream); err != nil {
+			return nil, err
+		}
+		p.ownedPool = true
+	case conf.Target == "":
+		return nil, errors.New("proxy target is empty")
+	default:
+		if p.target, err = url.Parse(conf.Target); err != nil {
+			return nil, fmt.Errorf("proxy target(%s) parse error: %w", conf.Target, err)
+		}
+		if p.target.Scheme == "" || p.target.Host == "" {
+			return nil, fmt.Errorf("proxy target(%s) must be absolute url", conf.Target)
+		}
+	}
+	defer func() {
+		if err != nil && p.ownedPool {
+			p.pool.Close()
+		}
+	}()
+	for _, rw := range conf.Rewrite {
+		re, err := regexp.Compile(rw.Match)
+		if err != nil {
+			return nil, fmt.Errorf("proxy rewrite(%s) compile error: %w", rw.Match, err)
+		}
+		p.rewrites = append(p.rewrites, &proxyRewrite{re: re, replace: rw.Replace})
+	}
+	transport := conf.Transport
+	if transport == nil && conf.Client != nil {
+		cli, err := NewProxyClient(conf.Client)
+		if err != nil {
+			return nil, err
+		}
+		transport = cli.Transport
+	}
+	if transport == nil {
+		transport = httpCli.Transport
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		Transport:      transport,
+		FlushInterval:  time.Duration(conf.FlushInterval),
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+	return p, nil
+}
+
+// Close 释放由 ProxyConfig.Upstream 创建的节点池
+func (p *ReverseProxy) Close() {
+	if p.ownedPool {
+		p.pool.Close()
+	}
+}
+
+// ReverseProxyHandler 配置错误时 panic，运行时加载配置请使用 NewReverseProxy
+func ReverseProxyHandler(conf *ProxyConfig) gin.HandlerFunc {
+	p, err := NewReverseProxy(conf)
+	if err != nil {
+		panic(err)
+	}
+	return p.Handler()
+}
+
+func (p *ReverseProxy) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
+
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.serve(w, r, nil)
+}
+
+// serve breakers 不为空时按 upstream host 熔断，客户端取消不计入熔断结果
+func (p *ReverseProxy) serve(w http.ResponseWriter, r *http.Request, breakers *BreakerGroup) {
+	if p.pool == nil && breakers == nil {
+		p.proxy.ServeHTTP(w, r)
+		return
+	}
+	st, target := &proxyState{}, p.target
+	if p.pool != nil {
+		b, err := p.pool.Pick(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		st.backend, target = b, b.URL
+	}
+	if breakers != nil {
+		done, err := breakers.Allow(target.Host)
+		if err != nil {
+			if st.backend != nil {
+				st.backend.release()
+			}
+			writeError(w, err)
+			return
+		}
+		defer func() {
+			switch {
+			case errors.Is(r.Context().Err(), context.Canceled):
+				done(BreakerIgnore)
+			case st.err != nil:
+				done(BreakerFailure)
+			default:
+				done(BreakerSuccess)
+			}
+		}()
+	}
+	p.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyStateKey{}, st)))
+	if st.backend != nil {
+		st.backend.Done(st.err)
+	}
+}
+
+// stripPathPrefix 按路径段去掉前缀，/api 不匹配 /apiary
+func stripPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "/", true
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return path[len(prefix):], true
+	}
+	return path, false
+}
+
+func (p *ReverseProxy) rewrite(pr *httputil.ProxyRequest) {
+	out := pr.Out
+	if p.conf.StripPrefix != "" || len(p.rewrites) > 0 {
+		reqPath, rawPath := out.URL.Path, out.URL.RawPath
+		if prefix := strings.TrimSuffix(p.conf.StripPrefix, "/"); prefix != "" {
+			var ok bool
+			if reqPath, ok = stripPathPrefix(reqPath, prefix); ok && rawPath != "" {
+				// 保留 %2F 等编码，供依赖原始路径的 upstream 使用
+				if rawPath, ok = stripPathPrefix(rawPath, (&url.URL{Path: prefix}).EscapedPath()); !ok {
+					rawPath = ""
+				}
+			}
+		}
+		for _, rw := range p.rewrites {
+			if rw.re.MatchString(reqPath) {
+				reqPath, rawPath = rw.re.ReplaceAllString(reqPath, rw.replace), ""
+				break
+			}
+		}
+		if !strings.HasPrefix(reqPath, "/") {
+			reqPath = "/" + reqPath
+		}
+		out.URL.Path, out.URL.RawPath = reqPath, rawPath
+	}
+	target := p.target
+	if st, ok := pr.In.Context().Value(proxyStateKey{}).(*proxyState); ok && st.backend != nil {
+		target = st.backend.URL
+	}
+	pr.SetURL(target)
+	if p.conf.PreserveHost {
+		out.Host = pr.In.Host
+	}
+	if p.conf.TrustForwarded {
+		// Rewrite 模式下 ReverseProxy 已删除入站的 X-Forwarded-*、Forwarded，信任时带回后追加
+		for _, k := range []string{"X-Forwarded-For", "Forwarded"} {
+			if prior := pr.In.Header[k]; len(prior) > 0 {
+				out.Header[k] = append([]string(nil), prior...)
+			}
+		}
+	}
+	pr.SetXForwarded()
+	setForwarded(pr.In, out.Header)
+	propagateTimeout(pr.In.Context(), out.Header)
+	if p.conf.ModifyRequest != nil {
+		p.conf.ModifyRequest(out)
+	}
+}
+
+// setForwarded RFC 7239 Forwarded 头
+func setForwarded(in *http.Request, h http.Header) {
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	elem := "proto=" + proto
+	if ip, _, err := net.SplitHostPort(in.RemoteAddr); err == nil {
+		if strings.Contains(ip, ":") {
+			ip = `"[` + ip + `]"`
+		}
+		elem = "for=" + ip + ";host=" + quoteForwarded(in.Host) + ";" + elem
+	}
+	if prior := strings.Join(h.Values("Forwarded"), ", "); prior != "" {
+		elem = prior + ", " + elem
+	}
+	h.Set("Forwarded", elem)
+}
+
+func quoteForwarded(v string) string {
+	if strings.ContainsAny(v, ":[]\",;= ") {
+		return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	}
+	return v
+}
+
+func (p *ReverseProxy) modifyResponse(resp *http.Response) error {
+	if st, ok := resp.Request.Context().Value(proxyStateKey{}).(*proxyState); ok {
+		st.err = upstreamError(resp, nil)
+	}
+	if p.conf.ModifyResponse != nil {
+		return p.conf.ModifyResponse(resp)
+	}
+	return nil
+}
+
+func (p *ReverseProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if st, ok := r.Context().Value(proxyStateKey{}).(*proxyState); ok && st.err == nil && !errors.Is(err, context.Canceled) {
+		st.err = err
+	}
+	proxyErrorHandler(w, r, err)
+}
+
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		e  = ecode.BadGatewayErr
+		ne net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		e = ClientClosedErr
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		e = GatewayTimeoutErr
+	}
+	xlog.Errorf("proxy %s %s error: %v", r.Method, r.URL.String(), err)
+	writeError(w, e)
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"container/list"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReverseProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"path":      r.URL.Path,
+			"host":      r.Host,
+			"x_token":   r.Header.Get("X-Token"),
+			"xff":       r.Header.Get("X-Forwarded-For"),
+			"xf_host":   r.Header.Get("X-Forwarded-Host"),
+			"forwarded": r.Header.Get("Forwarded"),
+		})
+	}))
+	defer upstream.Close()
+
+	g := gin.New()
+	g.Any("/api/*path", ReverseProxyHandler(&ProxyConfig{
+		Target:      upstream.URL + "/v2",
+		StripPrefix: "/api",
+		Rewrite:     []ProxyRewrite{{Match: `^/users/(\d+)$`, Replace: "/user/$1"}},
+	}))
+	g.Any("/trusted/*path", ReverseProxyHandler(&ProxyConfig{Target: upstream.URL, TrustForwarded: true}))
+	g.Any("/down", ReverseProxyHandler(&ProxyConfig{Target: "http://127.0.0.1:1"}))
+
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/users/42", nil)
+	req.Host = "pay.gopay.ink"
+	req.Header.Set("Connection", "X-Token")
+	req.Header.Set("X-Token", "hop")
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Set("Forwarded", "for=1.1.1.1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got := map[string]string{}
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	want := map[string]string{
+		"path":      "/v2/user/42",
+		"host":      upstream.Listener.Addr().String(),
+		"x_token":   "",
+		"xff":       "127.0.0.1",
+		"xf_host":   "pay.gopay.ink",
+		"forwarded": "for=127.0.0.1;host=pay.gopay.ink;proto=http",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("upstream %s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// 信任时追加到客户端传入的 X-Forwarded-For、Forwarded 之后
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/trusted/x", nil)
+	req.Host = "pay.gopay.ink"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	req.Header.Add("Forwarded", "for=1.1.1.1")
+	req.Header.Add("Forwarded", "for=2.2.2.2")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got = map[string]string{}
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	if got["xff"] != "1.1.1.1, 127.0.0.1" || got["forwarded"] != "for=1.1.1.1, for=2.2.2.2, for=127.0.0.1;host=pay.gopay.ink;proto=http" {
+		t.Errorf("trusted xff = %q, forwarded = %q", got["xff"], got["forwarded"])
+	}
+
+	resp, err = http.Get(srv.URL + "/down")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"code":502,"message":"service offline, unavailable"}` {
+		t.Fatalf("proxy error body = %s", body)
+	}
+}
+
+func TestReverseProxyStripPrefix(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.EscapedPath()))
+	}))
+	defer upstream.Close()
+	g := gin.New()
+	g.NoRoute(ReverseProxyHandler(&ProxyConfig{Target: upstream.URL, StripPrefix: "/api/"}))
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	for path, want := range map[string]string{
+		"/api":             "/",
+		"/api/users":       "/users",
+		"/apiary":          "/apiary",
+		"/api/files/a%2Fb": "/files/a%2Fb",
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s proxied to %s, want %s", path, body, want)
+		}
+	}
+}
+
+func TestPureProxyCache(t *testing.T) {
+	pc := &pureProxyCache{ll: list.New(), items: make(map[pureProxyKey]*list.Element)}
+	first := &ReverseProxy{}
+	pc.add(pureProxyKey{host: "http://h0"}, first)
+	if p := pc.add(pureProxyKey{host: "http://h0"}, &ReverseProxy{}); p != first {
+		t.Fatal("add existing key should return cached proxy")
+	}
+	for i := 1; i <= pureProxyMax; i++ {
+		pc.add(pureProxyKey{host: "http://h" + strconv.Itoa(i)}, &ReverseProxy{})
+	}
+	if n := pc.len(); n != pureProxyMax {
+		t.Fatalf("len = %d, want %d", n, pureProxyMax)
+	}
+	if pc.get(pureProxyKey{host: "http://h0"}) != nil || pc.get(pureProxyKey{host: "http://h1"}) == nil {
+		t.Fatal("least recently used proxy should be evicted")
+	}
+}
+
+func TestGinPureProxyOptions(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	// 超时和 5xx 均计入熔断失败
+	breakers := NewBreakerGroup(&BreakerConfig{FailureThreshold: 2})
+
+	g := gin.New()
+	g.Any("/*path", func(c *gin.Context) {
+		GinPureProxy(c, upstream.URL, WithProxyTimeout(20*time.Millisecond), WithCircuitBreaker(breakers))
+	})
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if code, _ := jwtCode(t, do("/slow")); code != GatewayTimeoutErr.Code() {
+		t.Fatalf("timeout code = %d", code)
+	}
+	if w := do("/error"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("upstream status = %d", w.Code)
+	}
+	if code, _ := jwtCode(t, do("/error")); code != BreakerOpenErr.Code() {
+		t.Fatalf("breaker open code = %d", code)
+	}
+}
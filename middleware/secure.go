@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xtime"
+)
+
+const (
+	// CSPNonceSource 在 CSP 来源中使用，每个请求替换为 'nonce-<随机值>'
+	CSPNonceSource = "'nonce'"
+
+	cspNonceKey = "web/csp_nonce"
+)
+
+var HostNotAllowedErr = ecode.New(http.StatusBadRequest, "HOST_NOT_ALLOWED", "host not allowed")
+
+// CSP Content-Security-Policy 指令 -> 来源，例如 {"default-src": ["'self'"], "script-src": ["'self'", CSPNonceSource]}
+type CSP map[string][]string
+
+// Add 追加指令的来源，upgrade-insecure-requests 等无来源的指令只传 directive
+func (p CSP) Add(directive string, sources ...string) CSP {
+	p[directive] = append(p[directive], sources...)
+	return p
+}
+
+// Build 按指令名排序输出，nonce 为空时 CSPNonceSource 原样输出
+func (p CSP) Build(nonce string) string {
+	directives := make([]string, 0, len(p))
+	for d := range p {
+		directives = append(directives, d)
+	}
+	sort.Strings(directives)
+	var b strings.Builder
+	for i, d := range directives {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(d)
+		for _, s := range p[d] {
+			if s == CSPNonceSource && nonce != "" {
+				s = "'nonce-" + nonce + "'"
+			}
+			b.WriteString(" " + s)
+		}
+	}
+	return b.String()
+}
+
+func (p CSP) hasNonce() bool {
+	for _, sources := range p {
+		for _, s := range sources {
+			if s == CSPNonceSource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SecureHeadersConfig 字符串类型的响应头为空时使用默认值，设置为 - 时不输出
+type SecureHeadersConfig struct {
+	AllowedHosts    []string          `json:"allowed_hosts" yaml:"allowed_hosts" toml:"allowed_hosts"`             // 允许的 Host，支持 *.example.com 通配，其他 Host 返回 400，为空不校验
+	SSLRedirect     bool              `json:"ssl_redirect" yaml:"ssl_redirect" toml:"ssl_redirect"`                // http 请求重定向到 https，GET、HEAD 使用 301，其他方法使用 308
+	SSLHost         string            `json:"ssl_host" yaml:"ssl_host" toml:"ssl_host"`                            // 重定向使用的 host，为空使用请求的 Host
+	SSLProxyHeaders map[string]string `json:"ssl_proxy_headers" yaml:"ssl_proxy_headers" toml:"ssl_proxy_headers"` // 代理终止 TLS 时判断 https 的请求头，default X-Forwarded-Proto: https
+
+	HSTSMaxAge            xtime.Duration `json:"hsts_max_age" yaml:"hsts_max_age" toml:"hsts_max_age"`                                  // 仅 https 请求输出，default 365 天，小于 0 不输出
+	HSTSIncludeSubdomains bool           `json:"hsts_include_subdomains" yaml:"hsts_include_subdomains" toml:"hsts_include_subdomains"` // HSTS includeSubDomains
+	HSTSPreload           bool           `json:"hsts_preload" yaml:"hsts_preload" toml:"hsts_preload"`                                  // HSTS preload
+
+	ContentTypeOptions string `json:"content_type_options" yaml:"content_type_options" toml:"content_type_options"` // X-Content-Type-Options，default nosniff
+	FrameOptions       string `json:"frame_options" yaml:"frame_options" toml:"frame_options"`                      // X-Frame-Options，default DENY
+	ReferrerPolicy     string `json:"referrer_policy" yaml:"referrer_policy" toml:"referrer_policy"`                // Referrer-Policy，default strict-origin-when-cross-origin
+	PermissionsPolicy  string `json:"permissions_policy" yaml:"permissions_policy" toml:"permissions_policy"`       // Permissions-Policy，为空不输出
+
+	CSP           CSP  `json:"csp" yaml:"csp" toml:"csp"`                                     // Content-Security-Policy，为空不输出，包含 CSPNonceSource 时每个请求生成 nonce
+	CSPReportOnly bool `json:"csp_report_only" yaml:"csp_report_only" toml:"csp_report_only"` // 使用 Content-Security-Policy-Report-Only，只上报不拦截
+}
+
+// SecureHeaders gin middleware，校验 Host、http 重定向到 https 并输出安全相关响应头，conf 为 nil 时使用默认值
+// 模板中使用 CSP nonce：c.HTML(200, "index.html", gin.H{"nonce": middleware.CSPNonce(c)})
+func SecureHeaders(conf *SecureHeadersConfig) gin.HandlerFunc {
+	if conf == nil {
+		conf = &SecureHeadersConfig{}
+	}
+	proxyHeaders := conf.SSLProxyHeaders
+	if len(proxyHeaders) == 0 {
+		proxyHeaders = map[string]string{"X-Forwarded-Proto": "https"}
+	}
+	var hsts string
+	if maxAge := durationOr(conf.HSTSMaxAge, 365*24*time.Hour); conf.HSTSMaxAge >= 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+		if conf.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if conf.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	headers := make([][2]string, 0, 4)
+	for _, h := range [][3]string{
+		{"X-Content-Type-Options", conf.ContentTypeOptions, "nosniff"},
+		{"X-Frame-Options", conf.FrameOptions, "DENY"},
+		{"Referrer-Policy", conf.ReferrerPolicy, "strict-origin-when-cross-origin"},
+		{"Permissions-Policy", conf.PermissionsPolicy, ""},
+	} {
+		if v := stringOr(h[1], h[2]); v != "" && v != "-" {
+			headers = append(headers, [2]string{h[0], v})
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if conf.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	var csp string
+	cspNonce := conf.CSP.hasNonce()
+	if len(conf.CSP) > 0 && !cspNonce {
+		csp = conf.CSP.Build("")
+	}
+	hosts := &CORSConfig{AllowOrigins: conf.AllowedHosts}
+
+	return func(c *gin.Context) {
+		if len(conf.AllowedHosts) > 0 && !hosts.AllowOrigin(stripPort(c.Request.Host)) {
+			writeErrorStatus(c.Writer, http.StatusBadRequest, HostNotAllowedErr)
+			c.Abort()
+			return
+		}
+		https := isHTTPS(c.Request, proxyHeaders)
+		if conf.SSLRedirect && !https {
+			status := http.StatusPermanentRedirect
+			if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+				status = http.StatusMovedPermanently
+			}
+			c.Redirect(status, "https://"+stringOr(conf.SSLHost, c.Request.Host)+c.Request.URL.RequestURI())
+			c.Abort()
+			return
+		}
+		h := c.Writer.Header()
+		if https && hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		for _, kv := range headers {
+			h.Set(kv[0], kv[1])
+		}
+		if cspNonce {
+			nonce := newCSPNonce()
+			c.Set(cspNonceKey, nonce)
+			h.Set(cspHeader, conf.CSP.Build(nonce))
+		} else if csp != "" {
+			h.Set(cspHeader, csp)
+		}
+		c.Next()
+	}
+}
+
+// CSPNonce 获取当前请求的 CSP nonce，用于模板中的 <script nonce="{{.nonce}}">
+func CSPNonce(c *gin.Context) string {
+	return c.GetString(cspNonceKey)
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// isHTTPS 代理请求头可被客户端伪造，仅在代理会覆盖这些请求头时使用默认配置
+func isHTTPS(r *http.Request, proxyHeaders map[string]string) bool {
+	if r.TLS != nil {
+		return true
+	}
+	for k, v := range proxyHeaders {
+		if strings.EqualFold(r.Header.Get(k), v) {
+			return true
+		}
+	}
+	return false
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
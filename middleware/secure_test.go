@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestCSPBuild(t *testing.T) {
+	csp := CSP{}.Add("script-src", "'self'", CSPNonceSource).Add("default-src", "'self'").Add("upgrade-insecure-requests")
+	if got, want := csp.Build("abc"), "default-src 'self'; script-src 'self' 'nonce-abc'; upgrade-insecure-requests"; got != want {
+		t.Fatalf("Build = %q, want %q", got, want)
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	g := gin.New()
+	g.Use(SecureHeaders(&SecureHeadersConfig{
+		AllowedHosts:          []string{"example.com", "*.example.com"},
+		SSLRedirect:           true,
+		HSTSMaxAge:            xtime.Duration(24 * time.Hour),
+		HSTSIncludeSubdomains: true,
+		FrameOptions:          "SAMEORIGIN",
+		ReferrerPolicy:        "-",
+		PermissionsPolicy:     "camera=()",
+		CSP:                   CSP{"default-src": {"'self'"}, "script-src": {"'self'", CSPNonceSource}},
+	}))
+	g.Any("/page", func(c *gin.Context) { c.String(http.StatusOK, CSPNonce(c)) })
+	do := func(method, host, proto string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/page?a=1", nil)
+		req.Host = host
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodGet, "evil.com", "https"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "host not allowed") {
+		t.Fatalf("host: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "api.example.com:8080", ""); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://api.example.com:8080/page?a=1" {
+		t.Fatalf("redirect: %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := do(http.MethodPost, "example.com", ""); w.Code != http.StatusPermanentRedirect {
+		t.Fatalf("post redirect: %d", w.Code)
+	}
+
+	w := do(http.MethodGet, "example.com", "https")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	nonce := w.Body.String()
+	want := map[string]string{
+		"Strict-Transport-Security": "max-age=86400; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Referrer-Policy":           "",
+		"Permissions-Policy":        "camera=()",
+		"Content-Security-Policy":   "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'",
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if nonce == "" || do(http.MethodGet, "example.com", "https").Body.String() == nonce {
+		t.Fatalf("nonce should be unique per request: %q", nonce)
+	}
+}
+
+func TestSecureHeadersDefault(t *testing.T) {
+	g := gin.New()
+	g.Use(SecureHeaders(nil))
+	g.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Strict-Transport-Security") != "" || w.Header().Get("Content-Security-Policy") != "" ||
+		w.Header().Get("X-Frame-Options") != "DENY" || w.Header().Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+		t.Fatalf("default: %d %v", w.Code, w.Header())
+	}
+}
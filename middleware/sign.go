@@ -0,0 +1,501 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+	"github.com/go-pay/xtime"
+)
+
+const (
+	SignTypeMD5        = "MD5"
+	SignTypeHMACSHA256 = "HMAC-SHA256"
+	SignTypeRSA2       = "RSA2"
+
+	signAppIdKey = "web/sign_app_id"
+)
+
+var (
+	SignMissingErr     = ecode.New(http.StatusUnauthorized, "SIGN_MISSING", "sign missing")
+	SignInvalidErr     = ecode.New(http.StatusUnauthorized, "SIGN_INVALID", "sign invalid")
+	SignTimestampErr   = ecode.New(http.StatusUnauthorized, "SIGN_TIMESTAMP_INVALID", "timestamp out of range")
+	SignNonceReusedErr = ecode.New(http.StatusUnauthorized, "SIGN_NONCE_REUSED", "nonce already used")
+	SignAppNotFoundErr = ecode.New(http.StatusUnauthorized, "APP_NOT_FOUND", "app not found")
+
+	signAlgorithmsMu sync.RWMutex
+	signAlgorithms   = map[string]SignAlgorithm{
+		SignTypeMD5:        md5Sign{},
+		SignTypeHMACSHA256: hmacSHA256Sign{},
+		SignTypeRSA2:       rsa2Sign{},
+	}
+	signPublicKeys  sync.Map // PEM -> crypto.PublicKey
+	signPrivateKeys sync.Map // PEM -> *rsa.PrivateKey
+)
+
+// SignAlgorithm 签名算法，content 为规范化后的待签名字符串
+type SignAlgorithm interface {
+	Sign(key *SignKey, content []byte) (string, error)
+	Verify(key *SignKey, content []byte, sign string) error
+}
+
+// SignParamsFunc 提取参与签名的参数
+type SignParamsFunc func(r *http.Request, body []byte) (map[string]string, error)
+
+// SignCanonicalizer 将参数规范化为待签名字符串，exclude 中的字段不参与签名
+type SignCanonicalizer func(params map[string]string, exclude map[string]bool) []byte
+
+// SignKeyStore 按 app_id 查询签名密钥，app 不存在时返回 nil, nil
+type SignKeyStore interface {
+	SignKey(ctx context.Context, appId string) (*SignKey, error)
+}
+
+type SignKey struct {
+	SignType    string `json:"sign_type" yaml:"sign_type" toml:"sign_type"`          // 该 app 使用的算法，不为空时请求的 sign_type 必须一致
+	Secret      string `json:"secret" yaml:"secret" toml:"secret"`                   // MD5、HMAC-SHA256 密钥
+	PublicKey   string `json:"public_key" yaml:"public_key" toml:"public_key"`       // 商户 RSA 公钥，用于验签和加密响应，PEM 或不带头尾的 base64
+	PrivateKey  string `json:"private_key" yaml:"private_key" toml:"private_key"`    // 平台 RSA 私钥，用于响应签名和解密请求，PKCS1、PKCS8 格式
+	EncryptType string `json:"encrypt_type" yaml:"encrypt_type" toml:"encrypt_type"` // 报文加密方式 AES-GCM、RSA，为空不加密
+	EncryptKey  string `json:"encrypt_key" yaml:"encrypt_key" toml:"encrypt_key"`    // AES-GCM 密钥，base64 编码的 16、24、32 字节
+}
+
+// SignKeyMap app_id -> 密钥，适用于配置文件中的固定商户
+type SignKeyMap map[string]*SignKey
+
+func (m SignKeyMap) SignKey(_ context.Context, appId string) (*SignKey, error) {
+	return m[appId], nil
+}
+
+type SignConfig struct {
+	AppIdField     string         `json:"app_id_field" yaml:"app_id_field" toml:"app_id_field"`             // default app_id，header: 前缀表示从请求头获取，例如 header:X-App-Id，其值以请求头名为参数名参与签名
+	SignField      string         `json:"sign_field" yaml:"sign_field" toml:"sign_field"`                   // default sign，同上支持 header:
+	SignTypeField  string         `json:"sign_type_field" yaml:"sign_type_field" toml:"sign_type_field"`    // default sign_type，同上支持 header:
+	TimestampField string         `json:"timestamp_field" yaml:"timestamp_field" toml:"timestamp_field"`    // default timestamp，支持秒、毫秒时间戳和 2006-01-02 15:04:05，同 AppIdField 支持 header: 并参与签名
+	NonceField     string         `json:"nonce_field" yaml:"nonce_field" toml:"nonce_field"`                // default nonce，同 AppIdField 支持 header: 并参与签名
+	Exclude        []string       `json:"exclude" yaml:"exclude" toml:"exclude"`                            // 额外不参与签名的字段，sign、sign_type 始终不参与
+	SignType       string         `json:"sign_type" yaml:"sign_type" toml:"sign_type"`                      // 密钥未指定算法时使用，default HMAC-SHA256
+	AllowSignTypes []string       `json:"allow_sign_types" yaml:"allow_sign_types" toml:"allow_sign_types"` // 密钥未指定算法时请求的 sign_type 还可以是这些算法，为空时只允许 SignType
+	Skew           xtime.Duration `json:"skew" yaml:"skew" toml:"skew"`                                     // 允许的时间戳偏差，default 5m，nonce 保存 2 倍该时长
+
+	Keys         SignKeyStore      `json:"-" yaml:"-" toml:"-"` // 必填
+	Nonces       NonceStore        `json:"-" yaml:"-" toml:"-"` // default 进程内存储，多实例部署请使用 RedisNonceStore
+	Params       SignParamsFunc    `json:"-" yaml:"-" toml:"-"` // default SignParams
+	Canonicalize SignCanonicalizer `json:"-" yaml:"-" toml:"-"` // default SortedCanonical
+}
+
+// RegisterSignAlgorithm 注册签名算法，例如 SM2、SM3，name 与请求的 sign_type 比较时不区分大小写
+func RegisterSignAlgorithm(name string, alg SignAlgorithm) {
+	signAlgorithmsMu.Lock()
+	defer signAlgorithmsMu.Unlock()
+	signAlgorithms[strings.ToUpper(name)] = alg
+}
+
+// SignContent 使用 signType 对应的算法签名，signType 为空时使用 key.SignType，均为空时使用 HMAC-SHA256
+func SignContent(key *SignKey, signType string, content []byte) (string, error) {
+	signType = stringOr(signType, stringOr(key.SignType, SignTypeHMACSHA256))
+	alg := signAlgorithm(strings.ToUpper(signType))
+	if alg == nil {
+		return "", fmt.Errorf("unsupported sign_type(%s)", signType)
+	}
+	return alg.Sign(key, content)
+}
+
+// VerifyContent 使用 signType 对应的算法验签，signType 为空时的规则同 SignContent
+func VerifyContent(key *SignKey, signType string, content []byte, sign string) error {
+	signType = stringOr(signType, stringOr(key.SignType, SignTypeHMACSHA256))
+	alg := signAlgorithm(strings.ToUpper(signType))
+	if alg == nil {
+		return fmt.Errorf("unsupported sign_type(%s)", signType)
+	}
+	return alg.Verify(key, content, sign)
+}
+
+func signAlgorithm(name string) SignAlgorithm {
+	signAlgorithmsMu.RLock()
+	defer signAlgorithmsMu.RUnlock()
+	return signAlgorithms[name]
+}
+
+type signVerifier struct {
+	conf     *SignConfig
+	appId    string
+	sign     string
+	signType string
+	ts       string
+	nonce    string
+	skew     time.Duration
+	exclude  map[string]bool
+
+	nonces       NonceStore
+	params       SignParamsFunc
+	canonicalize SignCanonicalizer
+}
+
+// SignVerify gin middleware，校验商户请求签名、时间戳和 nonce，conf.Keys 为空时 panic
+// 校验通过后可通过 SignAppId 获取 app_id，handler 仍可正常绑定请求体
+func SignVerify(conf *SignConfig) gin.HandlerFunc {
+	if conf == nil || conf.Keys == nil {
+		panic("sign verify: keys is nil")
+	}
+	v := &signVerifier{
+		conf:     conf,
+		appId:    stringOr(conf.AppIdField, "app_id"),
+		sign:     stringOr(conf.SignField, "sign"),
+		signType: stringOr(conf.SignTypeField, "sign_type"),
+		ts:       stringOr(conf.TimestampField, "timestamp"),
+		nonce:    stringOr(conf.NonceField, "nonce"),
+		skew:     durationOr(conf.Skew, 5*time.Minute),
+		exclude:  make(map[string]bool),
+
+		nonces:       conf.Nonces,
+		params:       conf.Params,
+		canonicalize: conf.Canonicalize,
+	}
+	if v.nonces == nil {
+		v.nonces = NewMemoryNonceStore()
+	}
+	if v.params == nil {
+		v.params = SignParams
+	}
+	if v.canonicalize == nil {
+		v.canonicalize = SortedCanonical
+	}
+	for _, f := range append([]string{v.sign, v.signType}, conf.Exclude...) {
+		if !strings.HasPrefix(f, "header:") {
+			v.exclude[f] = true
+		}
+	}
+	return v.handle
+}
+
+// SignAppId 已校验签名的 app_id，未经过 SignVerify 时返回空
+func SignAppId(c *gin.Context) string {
+	return c.GetString(signAppIdKey)
+}
+
+func (v *signVerifier) handle(c *gin.Context) {
+	if err := v.verify(c); err != nil {
+		writeError(c.Writer, err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func (v *signVerifier) verify(c *gin.Context) error {
+	var body []byte
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		var err error
+		if body, err = metadata.RequestBody(c.Request); err != nil {
+			return ecode.RequestErr.WithCause(err)
+		}
+	}
+	params, err := v.params(c.Request, body)
+	if err != nil {
+		return ecode.RequestErr.WithCause(err)
+	}
+	field := func(name string) string {
+		if h, ok := strings.CutPrefix(name, "header:"); ok {
+			return c.GetHeader(h)
+		}
+		return params[name]
+	}
+	// 请求头中的 app_id、timestamp、nonce 同样参与签名，防止重放时替换为新的时间戳和 nonce
+	if params == nil {
+		params = make(map[string]string)
+	}
+	for _, name := range []string{v.appId, v.ts, v.nonce} {
+		if h, ok := strings.CutPrefix(name, "header:"); ok {
+			if val := c.GetHeader(h); val != "" {
+				params[h] = val
+			}
+		}
+	}
+	appId, sign := field(v.appId), field(v.sign)
+	if appId == "" || sign == "" {
+		return SignMissingErr
+	}
+	ts, err := parseSignTimestamp(field(v.ts))
+	if err != nil {
+		return SignTimestampErr.WithCause(err)
+	}
+	if d := time.Since(ts); d > v.skew || d < -v.skew {
+		return SignTimestampErr
+	}
+	nonce := field(v.nonce)
+	if nonce == "" {
+		return SignMissingErr.WithCause(errors.New("nonce missing"))
+	}
+
+	ctx := c.Request.Context()
+	key, err := v.conf.Keys.SignKey(ctx, appId)
+	if err != nil {
+		return ecode.ServerErr.WithCause(err)
+	}
+	if key == nil {
+		return SignAppNotFoundErr
+	}
+	// 请求的 sign_type 只能是密钥或配置指定的算法，防止降级为 MD5 等较弱的算法
+	expected := stringOr(key.SignType, stringOr(v.conf.SignType, SignTypeHMACSHA256))
+	signType := field(v.signType)
+	switch {
+	case signType == "":
+		signType = expected
+	case strings.EqualFold(signType, expected):
+	case key.SignType == "" && slices.ContainsFunc(v.conf.AllowSignTypes, func(t string) bool { return strings.EqualFold(t, signType) }):
+	default:
+		return SignInvalidErr.WithCause(fmt.Errorf("sign_type(%s) not allowed", signType))
+	}
+	alg := signAlgorithm(strings.ToUpper(signType))
+	if alg == nil {
+		return SignInvalidErr.WithCause(fmt.Errorf("unsupported sign_type(%s)", signType))
+	}
+	if err = alg.Verify(key, v.canonicalize(params, v.exclude), sign); err != nil {
+		return SignInvalidErr.WithCause(err)
+	}
+
+	// 签名通过后再记录 nonce，防止伪造请求占用 nonce
+	ok, err := v.nonces.Add(ctx, appId+":"+nonce, 2*v.skew)
+	if err != nil {
+		return ecode.ServerErr.WithCause(err)
+	}
+	if !ok {
+		return SignNonceReusedErr
+	}
+	c.Set(signAppIdKey, appId)
+	return nil
+}
+
+// SignParams 默认的参数提取：query 参数，加上 form 表单或 JSON 对象的顶层字段
+// JSON 字段中的对象、数组按紧凑 JSON 参与签名，null 不参与
+func SignParams(r *http.Request, body []byte) (map[string]string, error) {
+	params := make(map[string]string)
+	for k, vs := range r.URL.Query() {
+		params[k] = vs[0]
+	}
+	if len(body) == 0 {
+		return params, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range form {
+			params[k] = vs[0]
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, err
+		}
+		for k, raw := range fields {
+			switch raw[0] {
+			case 'n':
+				continue
+			case '"':
+				var s string
+				if err := json.Unmarshal(raw, &s); err != nil {
+					return nil, err
+				}
+				params[k] = s
+			case '{', '[':
+				var buf bytes.Buffer
+				if err := json.Compact(&buf, raw); err != nil {
+					return nil, err
+				}
+				params[k] = buf.String()
+			default:
+				params[k] = string(raw)
+			}
+		}
+	}
+	return params, nil
+}
+
+// SortedCanonical 按字段名 ASCII 升序拼接为 k1=v1&k2=v2，空值和 exclude 中的字段不参与
+func SortedCanonical(params map[string]string, exclude map[string]bool) []byte {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if v != "" && !exclude[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(params[k])
+	}
+	return buf.Bytes()
+}
+
+func parseSignTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("timestamp missing")
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.ParseInLocation(time.DateTime, s, time.Local)
+}
+
+// md5Sign MD5(content&key=secret)，大写十六进制，校验时不区分大小写
+type md5Sign struct{}
+
+func (md5Sign) Sign(key *SignKey, content []byte) (string, error) {
+	if key.Secret == "" {
+		return "", errors.New("secret is empty")
+	}
+	sum := md5.Sum(append(content, "&key="+key.Secret...))
+	return strings.ToUpper(hex.EncodeToString(sum[:])), nil
+}
+
+func (a md5Sign) Verify(key *SignKey, content []byte, sign string) error {
+	expect, err := a.Sign(key, content)
+	if err != nil {
+		return err
+	}
+	return compareHexSign(expect, sign)
+}
+
+// hmacSHA256Sign HMAC-SHA256(secret, content)，大写十六进制，校验时不区分大小写
+type hmacSHA256Sign struct{}
+
+func (hmacSHA256Sign) Sign(key *SignKey, content []byte) (string, error) {
+	if key.Secret == "" {
+		return "", errors.New("secret is empty")
+	}
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	mac.Write(content)
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+func (a hmacSHA256Sign) Verify(key *SignKey, content []byte, sign string) error {
+	expect, err := a.Sign(key, content)
+	if err != nil {
+		return err
+	}
+	return compareHexSign(expect, sign)
+}
+
+// rsa2Sign SHA256WithRSA，签名为标准 base64，使用 PrivateKey 签名、PublicKey 验签
+type rsa2Sign struct{}
+
+func (rsa2Sign) Sign(key *SignKey, content []byte) (string, error) {
+	priv, err := signPrivateKey(key.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(content)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func (rsa2Sign) Verify(key *SignKey, content []byte, sign string) error {
+	pub, err := signPublicKey(key.PublicKey)
+	if err != nil {
+		return err
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("public key type %T is not rsa", pub)
+	}
+	sig, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(content)
+	return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], sig)
+}
+
+func compareHexSign(expect, sign string) error {
+	if !hmac.Equal([]byte(expect), []byte(strings.ToUpper(sign))) {
+		return errors.New("sign mismatch")
+	}
+	return nil
+}
+
+func signPublicKey(s string) (crypto.PublicKey, error) {
+	if s == "" {
+		return nil, errors.New("public key is empty")
+	}
+	if key, ok := signPublicKeys.Load(s); ok {
+		return key, nil
+	}
+	p := s
+	if !strings.Contains(p, "-----BEGIN") {
+		p = "-----BEGIN PUBLIC KEY-----\n" + p + "\n-----END PUBLIC KEY-----"
+	}
+	key, err := parsePublicKeyPEM(p)
+	if err != nil {
+		return nil, err
+	}
+	signPublicKeys.Store(s, key)
+	return key, nil
+}
+
+func signPrivateKey(s string) (*rsa.PrivateKey, error) {
+	if s == "" {
+		return nil, errors.New("private key is empty")
+	}
+	if key, ok := signPrivateKeys.Load(s); ok {
+		return key.(*rsa.PrivateKey), nil
+	}
+	der := []byte(s)
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	} else if bs, err := base64.StdEncoding.DecodeString(s); err == nil {
+		der = bs
+	} else {
+		return nil, errors.New("invalid private key")
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key type %T is not rsa", k)
+		}
+		key = rk
+	} else if key, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+		return nil, err
+	}
+	signPrivateKeys.Store(s, key)
+	return key, nil
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+)
+
+func TestSignVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	keys := SignKeyMap{
+		"app-hmac": {Secret: "hmac-secret"},
+		"app-md5":  {SignType: SignTypeMD5, Secret: "md5-secret"},
+		"app-rsa":  {SignType: SignTypeRSA2, PublicKey: base64.StdEncoding.EncodeToString(der)},
+	}
+	type order struct {
+		AppId  string `json:"app_id"`
+		Amount int    `json:"amount"`
+	}
+	g := gin.New()
+	g.POST("/pay", SignVerify(&SignConfig{Keys: keys}), func(c *gin.Context) {
+		req := &order{}
+		if err := c.ShouldBindJSON(req); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, SignAppId(c)+":"+strconv.Itoa(req.Amount))
+	})
+	g.POST("/form", SignVerify(&SignConfig{Keys: keys}), func(c *gin.Context) {
+		c.String(http.StatusOK, c.PostForm("amount"))
+	})
+	g.POST("/allow_md5", SignVerify(&SignConfig{Keys: keys, AllowSignTypes: []string{SignTypeMD5}}), func(c *gin.Context) {
+		c.String(http.StatusOK, c.PostForm("amount"))
+	})
+
+	hmacSign := func(content string) string {
+		mac := hmac.New(sha256.New, []byte("hmac-secret"))
+		mac.Write([]byte(content))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	jsonBody := func(nonce, ts, sign string) string {
+		return `{"app_id":"app-hmac","amount":100,"extra":{"b":1, "a":[1,2]},"memo":null,"nonce":"` + nonce + `","timestamp":"` + ts + `","sign":"` + sign + `"}`
+	}
+	signJSON := func(nonce, ts string) string {
+		return hmacSign(`amount=100&app_id=app-hmac&extra={"b":1,"a":[1,2]}&nonce=` + nonce + `&timestamp=` + ts)
+	}
+	do := func(path, contentType, body string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/pay", "application/json", jsonBody("n1", ts, signJSON("n1", ts)), nil)
+	if w.Body.String() != "app-hmac:100" {
+		t.Fatalf("hmac json = %s", w.Body.String())
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	tests := []struct {
+		name, body string
+		want       *ecode.Error
+	}{
+		{"replay", jsonBody("n1", ts, signJSON("n1", ts)), SignNonceReusedErr},
+		{"expired", jsonBody("n2", old, signJSON("n2", old)), SignTimestampErr},
+		{"tampered", strings.Replace(jsonBody("n3", ts, signJSON("n3", ts)), `"amount":100`, `"amount":1`, 1), SignInvalidErr},
+		{"missing sign", jsonBody("n4", ts, ""), SignMissingErr},
+		{"unknown app", strings.Replace(jsonBody("n5", ts, signJSON("n5", ts)), "app-hmac", "app-x", 1), SignAppNotFoundErr},
+	}
+	for _, tt := range tests {
+		code, msg := jwtCode(t, do("/pay", "application/json", tt.body, nil))
+		if code != tt.want.Code() || msg != tt.want.Message() {
+			t.Errorf("%s = %d %s, want %s", tt.name, code, msg, tt.want.Message())
+		}
+	}
+
+	// MD5：query + form，空值不参与签名
+	now := time.Now().Format(time.DateTime)
+	q := "app_id=app-md5&timestamp=" + url.QueryEscape(now)
+	form := "amount=200&empty=&nonce=n6"
+	sum := md5.Sum([]byte("amount=200&app_id=app-md5&nonce=n6&timestamp=" + now + "&key=md5-secret"))
+	w = do("/form?"+q+"&sign="+strings.ToUpper(hex.EncodeToString(sum[:])), "application/x-www-form-urlencoded", form, nil)
+	if w.Body.String() != "200" {
+		t.Fatalf("md5 form = %s", w.Body.String())
+	}
+
+	// 密钥未指定算法时也不允许请求降级为 MD5，除非配置了 AllowSignTypes
+	md5Sum := md5.Sum([]byte("amount=400&app_id=app-hmac&nonce=n8&timestamp=" + ts + "&key=hmac-secret"))
+	downgrade := url.Values{"app_id": {"app-hmac"}, "amount": {"400"}, "nonce": {"n8"}, "timestamp": {ts}, "sign_type": {"MD5"}, "sign": {hex.EncodeToString(md5Sum[:])}}
+	if code, _ := jwtCode(t, do("/form", "application/x-www-form-urlencoded", downgrade.Encode(), nil)); code != SignInvalidErr.Code() {
+		t.Fatalf("hmac downgrade code = %d", code)
+	}
+	if w = do("/allow_md5", "application/x-www-form-urlencoded", downgrade.Encode(), nil); w.Body.String() != "400" {
+		t.Fatalf("allowed md5 = %s", w.Body.String())
+	}
+
+	// RSA2：密钥指定算法后不允许请求降级为 MD5
+	content := "amount=300&app_id=app-rsa&nonce=n7&timestamp=" + ts
+	digest := sha256.Sum256([]byte(content))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	body := url.Values{"app_id": {"app-rsa"}, "amount": {"300"}, "nonce": {"n7"}, "timestamp": {ts}, "sign_type": {"MD5"}, "sign": {base64.StdEncoding.EncodeToString(sig)}}
+	if code, _ := jwtCode(t, do("/form", "application/x-www-form-urlencoded", body.Encode(), nil)); code != SignInvalidErr.Code() {
+		t.Fatalf("rsa downgrade code = %d", code)
+	}
+	body.Set("sign_type", "RSA2")
+	if w = do("/form", "application/x-www-form-urlencoded", body.Encode(), nil); w.Body.String() != "300" {
+		t.Fatalf("rsa form = %s", w.Body.String())
+	}
+}
+
+func TestSignVerifyCustom(t *testing.T) {
+	RegisterSignAlgorithm("plain", plainSign{})
+	redis, err := NewRedisNonceStore(&RedisConfig{Addr: fakeRedis(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close()
+	g := gin.New()
+	g.GET("/q", SignVerify(&SignConfig{
+		Keys:       SignKeyMap{"a": {Secret: "s"}},
+		AppIdField: "header:X-App-Id",
+		SignField:  "header:X-Sign",
+		SignType:   "PLAIN",
+		Nonces:     redis,
+		Canonicalize: func(params map[string]string, exclude map[string]bool) []byte {
+			return []byte(params["nonce"] + params["timestamp"])
+		},
+	}), func(c *gin.Context) {
+		c.String(http.StatusOK, SignAppId(c))
+	})
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for i, want := range []string{"a", ""} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/q?nonce=x&timestamp="+ts, nil)
+		r.Header.Set("X-App-Id", "a")
+		r.Header.Set("X-Sign", "s|x"+ts)
+		g.ServeHTTP(w, r)
+		if want != "" && w.Body.String() != want {
+			t.Fatalf("request %d = %s", i, w.Body.String())
+		}
+		if want == "" {
+			var rsp errorRsp
+			_ = json.Unmarshal(w.Body.Bytes(), &rsp)
+			if rsp.Message != SignNonceReusedErr.Message() {
+				t.Fatalf("request %d = %s", i, w.Body.String())
+			}
+		}
+	}
+	if ok, _ := redis.Add(context.Background(), "a:y", time.Minute); !ok {
+		t.Fatal("RedisNonceStore.Add() new nonce = false")
+	}
+}
+
+func TestSignVerifyHeaderFields(t *testing.T) {
+	key := &SignKey{Secret: "s"}
+	g := gin.New()
+	g.GET("/q", SignVerify(&SignConfig{
+		Keys:           SignKeyMap{"a": key},
+		AppIdField:     "header:X-App-Id",
+		SignField:      "header:X-Sign",
+		TimestampField: "header:X-Timestamp",
+		NonceField:     "header:X-Nonce",
+	}), func(c *gin.Context) {
+		c.String(http.StatusOK, SignAppId(c))
+	})
+	do := func(ts, nonce, sign string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/q?amount=1", nil)
+		r.Header.Set("X-App-Id", "a")
+		r.Header.Set("X-Timestamp", ts)
+		r.Header.Set("X-Nonce", nonce)
+		r.Header.Set("X-Sign", sign)
+		g.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sign, _ := SignContent(key, "", []byte("X-App-Id=a&X-Nonce=n1&X-Timestamp="+ts+"&amount=1"))
+	if body := do(ts, "n1", sign); body != "a" {
+		t.Fatalf("header fields = %s", body)
+	}
+	// 重放时替换时间戳和 nonce 请求头，签名不再匹配
+	if body := do(strconv.FormatInt(time.Now().Unix()+1, 10), "n2", sign); !strings.Contains(body, SignInvalidErr.Message()) {
+		t.Fatalf("replay with new headers = %s", body)
+	}
+}
+
+type plainSign struct{}
+
+func (plainSign) Sign(key *SignKey, content []byte) (string, error) {
+	return key.Secret + "|" + string(content), nil
+}
+
+func (plainSign) Verify(key *SignKey, content []byte, sign string) error {
+	if sign != key.Secret+"|"+string(content) {
+		return errors.New("mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+)
+
+// TimeoutHeader 剩余处理时间（毫秒），转发给下游以便下游提前放弃
+const TimeoutHeader = "X-Request-Timeout"
+
+var RequestTimeoutErr = ecode.New(http.StatusGatewayTimeout, "REQUEST_TIMEOUT", "request timeout")
+
+type timeoutPropagateKey struct{}
+
+type TimeoutConfig struct {
+	Timeout     xtime.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`                // 路由处理超时，必填
+	Propagate   bool           `json:"propagate" yaml:"propagate" toml:"propagate"`          // GinProxy、ReverseProxy 转发时携带 X-Request-Timeout 剩余时间
+	TrustHeader bool           `json:"trust_header" yaml:"trust_header" toml:"trust_header"` // 请求携带的 X-Request-Timeout 更短时使用该值，用于内部服务链路
+}
+
+// NoTimeout 取消当前请求上 Server 的 Read/WriteTimeout，用于 SSE、长轮询等长连接路由
+func NoTimeout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+		c.Next()
+	}
+}
+
+// Timeout gin middleware，见 TimeoutWithConfig
+func Timeout(d time.Duration) gin.HandlerFunc {
+	return TimeoutWithConfig(&TimeoutConfig{Timeout: xtime.Duration(d)})
+}
+
+// TimeoutWithConfig gin middleware，为请求 context 设置超时，handler 超时未完成时立即响应 504，之后 handler 的写入返回 http.ErrHandlerTimeout
+// 响应在 handler 返回前会被缓冲；handler 调用 Flush 后已输出的响应无法替换为 504，超时后只取消 context
+// 超时后仍会等待 handler 返回以保证 gin.Context 不被复用，handler 应在 ctx.Done() 后尽快返回
+// websocket、SSE 请求不处理，长连接路由请使用 NoTimeout 且不注册该中间件
+func TimeoutWithConfig(conf *TimeoutConfig) gin.HandlerFunc {
+	if conf == nil || conf.Timeout <= 0 {
+		panic("timeout: timeout must be greater than 0")
+	}
+	return func(c *gin.Context) {
+		if c.GetHeader("Upgrade") != "" || strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			c.Next()
+			return
+		}
+		d := time.Duration(conf.Timeout)
+		if conf.TrustHeader {
+			if ms, err := strconv.ParseInt(c.GetHeader(TimeoutHeader), 10, 64); err == nil && ms > 0 && time.Duration(ms)*time.Millisecond < d {
+				d = time.Duration(ms) * time.Millisecond
+			}
+		}
+		req, origin := c.Request, c.Writer
+		ctx, cancel := context.WithTimeout(req.Context(), d)
+		defer cancel()
+		if conf.Propagate {
+			ctx = context.WithValue(ctx, timeoutPropagateKey{}, true)
+		}
+		w := &timeoutWriter{ResponseWriter: origin, ctx: ctx, header: origin.Header().Clone()}
+		c.Writer, c.Request = w, req.WithContext(ctx)
+		done := make(chan any, 1)
+		go func() {
+			defer func() { done <- recover() }()
+			c.Next()
+		}()
+
+		var (
+			p        any
+			finished bool
+		)
+		select {
+		case p = <-done:
+			finished = true
+		case <-ctx.Done():
+			// handler 与超时同时完成时按完成处理
+			select {
+			case p = <-done:
+				finished = true
+			default:
+			}
+		}
+		// handler 在期限内返回且没有写入被拒绝时输出响应，即使之后 ctx 已超时或客户端已取消
+		// handler 在超时后才返回时同样响应 504
+		if !finished || !w.commit() {
+			w.timeout(errors.Is(ctx.Err(), context.DeadlineExceeded))
+			if !finished {
+				p = <-done
+			}
+			c.Writer, c.Request = origin, req
+			if p != nil {
+				xlog.Errorf("timeout: handler panic after %v: %v", d, p)
+			}
+			c.Abort()
+			return
+		}
+		c.Writer, c.Request = origin, req
+		if p != nil {
+			// 交给外层的 Recovery 处理
+			panic(p)
+		}
+	}
+}
+
+// propagateTimeout 按 ctx 剩余时间设置 X-Request-Timeout，仅在 TimeoutConfig.Propagate 开启时生效
+func propagateTimeout(ctx context.Context, h http.Header) {
+	if ok, _ := ctx.Value(timeoutPropagateKey{}).(bool); !ok {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	if ms := time.Until(deadline).Milliseconds(); ms > 0 {
+		h.Set(TimeoutHeader, strconv.FormatInt(ms, 10))
+	}
+}
+
+// timeoutWriter 缓冲 handler 的响应头和响应体，handler 完成或 Flush 时输出，超时后拒绝写入
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx       context.Context
+	mu        sync.Mutex
+	header    http.Header
+	buf       bytes.Buffer
+	status    int
+	written   bool
+	committed bool
+	timedOut  bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expiredLocked() || w.written {
+		return
+	}
+	w.status = code
+	if w.committed {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expiredLocked() {
+		return
+	}
+	w.written = true
+	if w.committed {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	if w.committed {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.committed {
+		return w.ResponseWriter.Status()
+	}
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.committed || !w.written {
+		return w.ResponseWriter.Size()
+	}
+	return w.buf.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expiredLocked() {
+		return
+	}
+	w.commitLocked()
+	w.ResponseWriter.Flush()
+}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("timeout: hijack not supported")
+}
+
+// Unwrap 供 http.ResponseController 获取底层 ResponseWriter
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// expiredLocked 超时后 handler 的写入立即失效，不依赖外层 goroutine 的调度
+func (w *timeoutWriter) expiredLocked() bool {
+	if !w.timedOut && w.ctx.Err() != nil {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// commit 输出缓冲的响应，超时后已拒绝过写入时返回 false
+func (w *timeoutWriter) commit() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.commitLocked()
+	return w.committed
+}
+
+func (w *timeoutWriter) commitLocked() {
+	if w.committed || w.timedOut {
+		return
+	}
+	w.committed = true
+	dst := w.ResponseWriter.Header()
+	for k := range dst {
+		if _, ok := w.header[k]; !ok {
+			delete(dst, k)
+		}
+	}
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	} else if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// timeout 拒绝之后的写入，deadline 为 true 且响应未输出时立即响应 504，客户端断开时不响应
+func (w *timeoutWriter) timeout(deadline bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timedOut = true
+	if w.committed || !deadline {
+		return
+	}
+	writeErrorStatus(w.ResponseWriter, http.StatusGatewayTimeout, RequestTimeoutErr)
+	w.ResponseWriter.Flush()
+}
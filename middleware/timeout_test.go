@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/xtime"
+)
+
+func TestTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"code":200,"data":"` + r.Header.Get(TimeoutHeader) + `"}`))
+	}))
+	defer upstream.Close()
+
+	lateWrite := make(chan error, 1)
+	handlerDone := make(chan struct{})
+	g := gin.New()
+	g.Use(gin.CustomRecovery(func(c *gin.Context, _ any) { c.AbortWithStatus(http.StatusInternalServerError) }))
+	g.Use(func(c *gin.Context) {
+		c.Header("X-Outer", "1")
+		c.Next()
+	})
+	g.GET("/fast", Timeout(time.Second), func(c *gin.Context) {
+		c.Header("X-Inner", "1")
+		c.String(http.StatusCreated, "fast")
+	})
+	g.GET("/slow", Timeout(50*time.Millisecond), func(c *gin.Context) {
+		defer close(handlerDone)
+		// 忽略 ctx 的 handler，超时后的写入被拒绝
+		time.Sleep(300 * time.Millisecond)
+		_, err := c.Writer.WriteString("late")
+		lateWrite <- err
+	})
+	g.GET("/ctx", Timeout(50*time.Millisecond), func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.String(http.StatusOK, c.Request.Context().Err().Error())
+	})
+	g.GET("/panic", Timeout(time.Second), func(c *gin.Context) {
+		panic("boom")
+	})
+	g.GET("/stream", Timeout(50*time.Millisecond), func(c *gin.Context) {
+		c.String(http.StatusOK, "part1,")
+		c.Writer.Flush()
+		<-c.Request.Context().Done()
+		_, err := c.Writer.WriteString("part2")
+		lateWrite <- err
+	})
+	g.GET("/proxy", TimeoutWithConfig(&TimeoutConfig{Timeout: xtime.Duration(800 * time.Millisecond), Propagate: true, TrustHeader: true}), func(c *gin.Context) {
+		rsp, err := GinProxy[string](c, upstream.URL, "/")
+		if err != nil {
+			c.String(http.StatusBadGateway, err.Error())
+			return
+		}
+		c.String(http.StatusOK, rsp)
+	})
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	get := func(path string, header http.Header) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, _ := io.ReadAll(resp.Body)
+		return resp, string(bs)
+	}
+
+	resp, body := get("/fast", nil)
+	if resp.StatusCode != http.StatusCreated || body != "fast" || resp.Header.Get("X-Outer") != "1" || resp.Header.Get("X-Inner") != "1" {
+		t.Fatalf("fast = %d %s %v", resp.StatusCode, body, resp.Header)
+	}
+
+	start := time.Now()
+	resp, body = get("/slow", nil)
+	if resp.StatusCode != http.StatusGatewayTimeout || body != `{"code":504,"message":"request timeout"}` || resp.Header.Get("X-Outer") != "1" {
+		t.Fatalf("slow = %d %s", resp.StatusCode, body)
+	}
+	// 504 在 handler 返回前送达客户端
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("slow response took %v", elapsed)
+	}
+	<-handlerDone
+	if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Fatalf("late write err = %v", err)
+	}
+
+	if resp, _ = get("/ctx", nil); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("ctx = %d", resp.StatusCode)
+	}
+	if resp, _ = get("/panic", nil); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("panic = %d", resp.StatusCode)
+	}
+	if resp, body = get("/stream", nil); resp.StatusCode != http.StatusOK || body != "part1," {
+		t.Fatalf("stream = %d %s", resp.StatusCode, body)
+	}
+	if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Fatalf("stream late write err = %v", err)
+	}
+
+	// 剩余时间传递给 upstream，请求携带更短的超时时使用请求的值
+	for _, tt := range []struct {
+		header   string
+		min, max int
+	}{{"", 1, 800}, {"200", 1, 200}, {"5000", 1, 800}} {
+		_, body = get("/proxy", http.Header{TimeoutHeader: {tt.header}})
+		ms, err := strconv.Atoi(body)
+		if err != nil || ms < tt.min || ms > tt.max {
+			t.Fatalf("propagated timeout with header %q = %q", tt.header, body)
+		}
+	}
+	ctx := context.WithValue(context.Background(), timeoutPropagateKey{}, true)
+	h := http.Header{}
+	if propagateTimeout(ctx, h); h.Get(TimeoutHeader) != "" {
+		t.Fatalf("propagateTimeout() without deadline = %v", h)
+	}
+}
+
+func TestNoTimeout(t *testing.T) {
+	g := gin.New()
+	slow := func(c *gin.Context) {
+		time.Sleep(150 * time.Millisecond)
+		c.String(http.StatusOK, "ok")
+	}
+	g.GET("/slow", slow)
+	g.GET("/stream", NoTimeout(), slow)
+	srv := httptest.NewUnstartedServer(g)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	get := func(path string) (string, error) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		bs, err := io.ReadAll(resp.Body)
+		return string(bs), err
+	}
+	if _, err := get("/slow"); err == nil {
+		t.Fatal("write after WriteTimeout should fail without NoTimeout")
+	}
+	if body, err := get("/stream"); err != nil || body != "ok" {
+		t.Fatalf("NoTimeout: %q, %v", body, err)
+	}
+}
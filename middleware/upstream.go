@@ -0,0 +1,338 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+	"github.com/go-pay/xlog"
+	"github.com/go-pay/xtime"
+)
+
+const (
+	StrategyRoundRobin = "round_robin"
+	StrategyWeighted   = "weighted"
+	StrategyLeastConn  = "least_conn"
+	StrategyHash       = "hash"
+
+	hashVirtualNodes = 160
+)
+
+var NoHealthyUpstreamErr = ecode.New(http.StatusServiceUnavailable, "NO_HEALTHY_UPSTREAM", "no healthy upstream")
+
+type UpstreamConfig struct {
+	Targets     []UpstreamTarget   `json:"targets" yaml:"targets" toml:"targets"`
+	Strategy    string             `json:"strategy" yaml:"strategy" toml:"strategy"`             // round_robin(default)、weighted、least_conn、hash
+	HashBy      string             `json:"hash_by" yaml:"hash_by" toml:"hash_by"`                // hash 策略的 key：client_ip(default) 或 header:X-Merchant-Id
+	MaxFails    int                `json:"max_fails" yaml:"max_fails" toml:"max_fails"`          // 连续失败多少次被动摘除，default 3，小于 0 关闭
+	FailTimeout xtime.Duration     `json:"fail_timeout" yaml:"fail_timeout" toml:"fail_timeout"` // 被动摘除时长，default 30s
+	SlowStart   xtime.Duration     `json:"slow_start" yaml:"slow_start" toml:"slow_start"`       // 恢复后权重从 0 线性增长到配置权重的时长，0 关闭，hash 策略不生效
+	HealthCheck *HealthCheckConfig `json:"health_check" yaml:"health_check" toml:"health_check"` // 主动健康检查，nil 关闭
+}
+
+type UpstreamTarget struct {
+	Addr   string `json:"addr" yaml:"addr" toml:"addr"`       // 例如 http://10.0.0.1:8080
+	Weight int    `json:"weight" yaml:"weight" toml:"weight"` // default 1
+}
+
+type HealthCheckConfig struct {
+	Path     string         `json:"path" yaml:"path" toml:"path"`             // 例如 /health，返回 2xx 为健康
+	Interval xtime.Duration `json:"interval" yaml:"interval" toml:"interval"` // default 10s
+	Timeout  xtime.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`    // default 2s
+}
+
+// Upstream upstream 节点池，支持负载均衡、主动健康检查、被动摘除和慢启动
+type Upstream struct {
+	conf     *UpstreamConfig
+	backends []*Backend
+	rr       uint64
+	mu       sync.Mutex // weighted 策略
+	ring     []hashNode
+	client   *http.Client
+	cancel   context.CancelFunc
+}
+
+// Backend upstream 节点
+type Backend struct {
+	URL    *url.URL
+	Weight int
+
+	up           *Upstream
+	inflight     atomic.Int64
+	fails        atomic.Int64
+	healthy      atomic.Bool  // 主动健康检查结果
+	ejectedUntil atomic.Int64 // 被动摘除截止时间，unix nano
+	recoveredAt  atomic.Int64 // 最近一次恢复时间，unix nano，用于慢启动
+	current      int          // weighted 策略的当前权重
+}
+
+type hashNode struct {
+	hash    uint32
+	backend *Backend
+}
+
+// NewUpstream 创建 upstream 节点池，配置了 HealthCheck 时启动后台检查，不再使用时调用 Close
+func NewUpstream(conf *UpstreamConfig) (*Upstream, error) {
+	if conf == nil || len(conf.Targets) == 0 {
+		return nil, errors.New("upstream targets is empty")
+	}
+	switch conf.Strategy {
+	case "":
+		conf.Strategy = StrategyRoundRobin
+	case StrategyRoundRobin, StrategyWeighted, StrategyLeastConn, StrategyHash:
+	default:
+		return nil, fmt.Errorf("unknown upstream strategy(%s)", conf.Strategy)
+	}
+	if conf.MaxFails == 0 {
+		conf.MaxFails = 3
+	}
+	if conf.FailTimeout <= 0 {
+		conf.FailTimeout = xtime.Duration(30 * time.Second)
+	}
+	u := &Upstream{conf: conf, client: httpCli}
+	for _, t := range conf.Targets {
+		target, err := url.Parse(t.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("upstream target(%s) parse error: %w", t.Addr, err)
+		}
+		if target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("upstream target(%s) must be absolute url", t.Addr)
+		}
+		b := &Backend{URL: target, Weight: t.Weight, up: u}
+		if b.Weight <= 0 {
+			b.Weight = 1
+		}
+		b.healthy.Store(true)
+		u.backends = append(u.backends, b)
+		for i := 0; i < hashVirtualNodes*b.Weight; i++ {
+			u.ring = append(u.ring, hashNode{hash: crc32.ChecksumIEEE([]byte(t.Addr + "#" + strconv.Itoa(i))), backend: b})
+		}
+	}
+	sort.Slice(u.ring, func(i, j int) bool { return u.ring[i].hash < u.ring[j].hash })
+	if hc := conf.HealthCheck; hc != nil && hc.Path != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		u.cancel = cancel
+		go u.healthCheck(ctx, hc)
+	}
+	return u, nil
+}
+
+// Close 停止主动健康检查
+func (u *Upstream) Close() {
+	if u.cancel != nil {
+		u.cancel()
+	}
+}
+
+// Backends 全部节点
+func (u *Upstream) Backends() []*Backend {
+	return u.backends
+}
+
+// Pick 按负载均衡策略选择一个可用节点，使用完毕后必须调用 Backend.Done
+func (u *Upstream) Pick(r *http.Request) (*Backend, error) {
+	now := time.Now()
+	var b *Backend
+	if u.conf.Strategy == StrategyHash {
+		b = u.pickHash(r, now)
+	} else {
+		alive := make([]*Backend, 0, len(u.backends))
+		for _, b := range u.backends {
+			if b.available(now) {
+				alive = append(alive, b)
+			}
+		}
+		if len(alive) == 0 {
+			return nil, NoHealthyUpstreamErr
+		}
+		switch u.conf.Strategy {
+		case StrategyWeighted:
+			b = u.pickWeighted(alive, now)
+		case StrategyLeastConn:
+			b = u.pickLeastConn(alive, now)
+		default:
+			b = u.pickRoundRobin(alive, now)
+		}
+	}
+	if b == nil {
+		return nil, NoHealthyUpstreamErr
+	}
+	b.inflight.Add(1)
+	return b, nil
+}
+
+// pickRoundRobin 慢启动期间按有效权重概率跳过节点
+func (u *Upstream) pickRoundRobin(alive []*Backend, now time.Time) *Backend {
+	n := atomic.AddUint64(&u.rr, 1)
+	for i := 0; i < len(alive); i++ {
+		b := alive[(n+uint64(i))%uint64(len(alive))]
+		if ratio := b.slowStartRatio(now); ratio >= 1 || float64(n%100) < ratio*100 {
+			return b
+		}
+	}
+	return alive[n%uint64(len(alive))]
+}
+
+// pickWeighted nginx smooth weighted round-robin
+func (u *Upstream) pickWeighted(alive []*Backend, now time.Time) *Backend {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var (
+		best  *Backend
+		total int
+	)
+	for _, b := range alive {
+		w := b.effectiveWeight(now)
+		b.current += w
+		total += w
+		if best == nil || b.current > best.current {
+			best = b
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (u *Upstream) pickLeastConn(alive []*Backend, now time.Time) *Backend {
+	var (
+		best      *Backend
+		bestScore float64
+	)
+	for _, b := range alive {
+		score := float64(b.inflight.Load()+1) / float64(b.effectiveWeight(now))
+		if best == nil || score < bestScore {
+			best, bestScore = b, score
+		}
+	}
+	return best
+}
+
+// pickHash 一致性哈希，节点不可用时顺延到环上下一个可用节点
+func (u *Upstream) pickHash(r *http.Request, now time.Time) *Backend {
+	key := metadata.ClientIP(r, r.Header)
+	if h, ok := strings.CutPrefix(u.conf.HashBy, "header:"); ok {
+		if v := r.Header.Get(h); v != "" {
+			key = v
+		}
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(u.ring), func(i int) bool { return u.ring[i].hash >= hash })
+	for i := 0; i < len(u.ring); i++ {
+		if b := u.ring[(idx+i)%len(u.ring)].backend; b.available(now) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (u *Upstream) healthCheck(ctx context.Context, hc *HealthCheckConfig) {
+	interval := durationOr(hc.Interval, 10*time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, b := range u.backends {
+			b.check(ctx, hc)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Done 上报本次请求结果，err 不为 nil 时计为一次失败，upstream 返回 5xx 时调用方需传入 error
+func (b *Backend) Done(err error) {
+	b.inflight.Add(-1)
+	conf := b.up.conf
+	if err == nil {
+		b.fails.Store(0)
+		return
+	}
+	if conf.MaxFails < 0 {
+		return
+	}
+	if fails := b.fails.Add(1); fails >= int64(conf.MaxFails) {
+		b.fails.Store(0)
+		until := time.Now().Add(time.Duration(conf.FailTimeout))
+		b.ejectedUntil.Store(until.UnixNano())
+		b.recoveredAt.Store(until.UnixNano())
+		xlog.Warnf("upstream %s ejected until %s after %d consecutive failures: %v", b.URL.Host, until.Format(time.DateTime), fails, err)
+	}
+}
+
+// release 未发出请求时归还节点，不计入成功或失败
+func (b *Backend) release() {
+	b.inflight.Add(-1)
+}
+
+// Inflight 当前进行中的请求数
+func (b *Backend) Inflight() int64 {
+	return b.inflight.Load()
+}
+
+// Healthy 节点当前是否可用
+func (b *Backend) Healthy() bool {
+	return b.available(time.Now())
+}
+
+func (b *Backend) available(now time.Time) bool {
+	return b.healthy.Load() && now.UnixNano() >= b.ejectedUntil.Load()
+}
+
+func (b *Backend) slowStartRatio(now time.Time) float64 {
+	slow := time.Duration(b.up.conf.SlowStart)
+	if slow <= 0 {
+		return 1
+	}
+	elapsed := now.Sub(time.Unix(0, b.recoveredAt.Load()))
+	if elapsed >= slow {
+		return 1
+	}
+	if elapsed < 0 {
+		return 0
+	}
+	return float64(elapsed) / float64(slow)
+}
+
+// effectiveWeight 慢启动期间按比例降低权重，放大 100 倍保留精度，最小为 1
+func (b *Backend) effectiveWeight(now time.Time) int {
+	w := int(float64(b.Weight*100) * b.slowStartRatio(now))
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+func (b *Backend) check(ctx context.Context, hc *HealthCheckConfig) {
+	ctx, cancel := context.WithTimeout(ctx, durationOr(hc.Timeout, 2*time.Second))
+	defer cancel()
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(b.URL.String(), "/")+hc.Path, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = b.up.client.Do(req); err == nil {
+			healthy = resp.StatusCode/100 == 2
+			resp.Body.Close()
+		}
+	}
+	if was := b.healthy.Swap(healthy); was != healthy {
+		if healthy {
+			b.recoveredAt.Store(time.Now().UnixNano())
+			xlog.Warnf("upstream %s health check recovered", b.URL.Host)
+			return
+		}
+		xlog.Warnf("upstream %s health check failed: %v", b.URL.Host, err)
+	}
+}
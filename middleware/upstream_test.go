@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-pay/xtime"
+)
+
+func newBackend(status *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+}
+
+func TestUpstreamWeighted(t *testing.T) {
+	u, err := NewUpstream(&UpstreamConfig{
+		Strategy: StrategyWeighted,
+		Targets:  []UpstreamTarget{{Addr: "http://a", Weight: 3}, {Addr: "http://b", Weight: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	count := map[string]int{}
+	for i := 0; i < 400; i++ {
+		b, err := u.Pick(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count[b.URL.Host]++
+		b.Done(nil)
+	}
+	if count["a"] != 300 || count["b"] != 100 {
+		t.Fatalf("weighted count = %v", count)
+	}
+}
+
+func TestUpstreamHash(t *testing.T) {
+	u, _ := NewUpstream(&UpstreamConfig{
+		Strategy: StrategyHash,
+		HashBy:   "header:X-Merchant-Id",
+		Targets:  []UpstreamTarget{{Addr: "http://a"}, {Addr: "http://b"}, {Addr: "http://c"}},
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Merchant-Id", "M10086")
+	first, _ := u.Pick(req)
+	first.Done(nil)
+	for i := 0; i < 10; i++ {
+		b, _ := u.Pick(req)
+		b.Done(nil)
+		if b != first {
+			t.Fatalf("hash pick %s, want %s", b.URL.Host, first.URL.Host)
+		}
+	}
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	var okStatus, badStatus atomic.Int32
+	okStatus.Store(http.StatusOK)
+	badStatus.Store(http.StatusBadGateway)
+	good, bad := newBackend(&okStatus), newBackend(&badStatus)
+	defer good.Close()
+	defer bad.Close()
+
+	u, err := NewUpstream(&UpstreamConfig{
+		Targets:     []UpstreamTarget{{Addr: good.URL}, {Addr: bad.URL}},
+		MaxFails:    2,
+		FailTimeout: xtime.Duration(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	// 被动摘除：bad 连续 2 次 5xx 后不再被选中
+	for i := 0; i < 10; i++ {
+		b, err := u.Pick(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get(b.URL.String())
+		b.Done(upstreamError(resp, err))
+		resp.Body.Close()
+		if i >= 4 && b.URL.String() == bad.URL {
+			t.Fatalf("ejected backend picked at %d", i)
+		}
+	}
+
+	// 主动健康检查：good 检查失败后无可用节点
+	okStatus.Store(http.StatusServiceUnavailable)
+	hu, _ := NewUpstream(&UpstreamConfig{
+		Targets:     []UpstreamTarget{{Addr: good.URL}},
+		HealthCheck: &HealthCheckConfig{Path: "/health", Interval: xtime.Duration(10 * time.Millisecond)},
+	})
+	defer hu.Close()
+	time.Sleep(50 * time.Millisecond)
+	if _, err = hu.Pick(req); !NoHealthyUpstreamErr.Is(err) {
+		t.Fatalf("Pick() err = %v, want NoHealthyUpstreamErr", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/go-pay/limiter"
+	"github.com/go-pay/web/middleware"
 	"github.com/go-pay/xtime"
 )
 
@@ -17,11 +18,15 @@ type hookType string
 type HookFunc func(c context.Context)
 
 type Config struct {
-	Addr         string          `json:"addr" yaml:"addr" toml:"addr"`                            // addr, default :2233
-	ReadTimeout  xtime.Duration  `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`    // read_timeout, default 60s
-	WriteTimeout xtime.Duration  `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"` // write_timeout, default 60s
-	Debug        bool            `json:"debug" yaml:"debug" toml:"debug"`                         // is show log
-	Limiter      *limiter.Config `json:"limiter" yaml:"limiter" toml:"limiter"`                   // interface limit
+	Addr         string                          `json:"addr" yaml:"addr" toml:"addr"`                            // addr, default :2233
+	ReadTimeout  xtime.Duration                  `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`    // read_timeout, default 60s
+	WriteTimeout xtime.Duration                  `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"` // write_timeout, default 60s
+	Debug        bool                            `json:"debug" yaml:"debug" toml:"debug"`                         // is show log
+	Limiter      *limiter.Config                 `json:"limiter" yaml:"limiter" toml:"limiter"`                   // interface limit
+	Concurrency  *middleware.ConcurrencyConfig   `json:"concurrency" yaml:"concurrency" toml:"concurrency"`       // 全局最大并发请求数，nil 或 max_inflight 为 0 不开启
+	CORS         *middleware.CORSConfig          `json:"cors" yaml:"cors" toml:"cors"`                            // cors, nil 不开启，websocket 使用其校验 Origin
+	Secure       *middleware.SecureHeadersConfig `json:"secure" yaml:"secure" toml:"secure"`                      // 安全响应头、https 重定向和 Host 校验，nil 不开启
+	Decompress   *middleware.DecompressConfig    `json:"decompress" yaml:"decompress" toml:"decompress"`          // 请求体解压，nil 不开启
 }
 
 type CommonRsp struct {
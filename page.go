@@ -1,8 +1,31 @@
 package web
 
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+)
+
+var (
+	pagerMu         sync.RWMutex
+	defaultPageSize = 20
+	maxPageSize     = 500
+	cursorSecret    = newCursorSecret()
+
+	InvalidCursorErr = ecode.New(400, "INVALID_CURSOR", "invalid cursor")
+)
+
 type Pager struct {
-	PageNo   int `json:"page_no"`
-	PageSize int `json:"page_size"`
+	PageNo   int `json:"page_no" form:"page_no"`
+	PageSize int `json:"page_size" form:"page_size"`
 }
 
 type pageRsp struct {
@@ -11,22 +34,216 @@ type pageRsp struct {
 }
 
 type page struct {
-	Total    int64 `json:"total"`
-	PageNo   int   `json:"page_no"`
-	PageSize int   `json:"page_size"`
+	Total      int64 `json:"total"`
+	PageNo     int   `json:"page_no"`
+	PageSize   int   `json:"page_size"`
+	TotalPages int64 `json:"total_pages"`
+	HasMore    bool  `json:"has_more"`
+}
+
+// SetPageSize 设置分页默认 page_size 和最大 page_size，小于等于 0 的值忽略
+func SetPageSize(def, max int) {
+	pagerMu.Lock()
+	defer pagerMu.Unlock()
+	if max > 0 {
+		maxPageSize = max
+	}
+	if def > 0 {
+		defaultPageSize = def
+	}
+	if defaultPageSize > maxPageSize {
+		defaultPageSize = maxPageSize
+	}
+}
+
+func pageSizeBounds() (def, max int) {
+	pagerMu.RLock()
+	defer pagerMu.RUnlock()
+	return defaultPageSize, maxPageSize
+}
+
+// BindPager 从 query/form/json 中绑定分页参数，并做默认值和边界修正
+func BindPager(c *gin.Context) (p Pager, err error) {
+	if err = c.ShouldBind(&p); err != nil {
+		return p, ecode.RequestErr.WithCause(err)
+	}
+	return p.Normalize(), nil
+}
+
+// Normalize PageNo 最小为 1，PageSize 为 0 时取默认值，超过最大值时取最大值
+func (p Pager) Normalize() Pager {
+	def, max := pageSizeBounds()
+	if p.PageNo < 1 {
+		p.PageNo = 1
+	}
+	if p.PageSize <= 0 {
+		p.PageSize = def
+	}
+	if p.PageSize > max {
+		p.PageSize = max
+	}
+	return p
+}
+
+// Offset 数据库查询偏移量
+func (p Pager) Offset() int {
+	p = p.Normalize()
+	return (p.PageNo - 1) * p.PageSize
+}
+
+// Limit 数据库查询条数
+func (p Pager) Limit() int {
+	return p.Normalize().PageSize
 }
 
 func (p Pager) Apply(total int64, data any) any {
-	if data == nil {
-		return nil
+	p = p.Normalize()
+	totalPages := int64(0)
+	if total > 0 {
+		totalPages = (total + int64(p.PageSize) - 1) / int64(p.PageSize)
 	}
 	res := &pageRsp{
-		List: data,
+		List: emptyList(data),
 		Page: page{
-			Total:    total,
-			PageNo:   p.PageNo,
-			PageSize: p.PageSize,
+			Total:      total,
+			PageNo:     p.PageNo,
+			PageSize:   p.PageSize,
+			TotalPages: totalPages,
+			HasMore:    int64(p.PageNo) < totalPages,
 		},
 	}
 	return res
 }
+
+// emptyList nil 或 nil slice 输出为 []，避免 json 输出 null
+func emptyList(data any) any {
+	if data == nil {
+		return []any{}
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return reflect.MakeSlice(v.Type(), 0, 0).Interface()
+	}
+	return data
+}
+
+// ============================================================================================================
+
+// CursorPager 游标分页，Cursor 为上一页返回的 next_cursor，首页为空
+type CursorPager struct {
+	Cursor   string `json:"cursor" form:"cursor"`
+	PageSize int    `json:"page_size" form:"page_size"`
+}
+
+type cursorPageRsp struct {
+	List any        `json:"list"`
+	Page cursorPage `json:"page"`
+}
+
+type cursorPage struct {
+	PageSize   int    `json:"page_size"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// SetCursorSecret 设置游标签名密钥，未设置时使用进程启动时生成的随机密钥，重启或多实例部署时游标会失效，生产环境务必设置
+func SetCursorSecret(secret []byte) {
+	if len(secret) == 0 {
+		panic("cursor secret is empty")
+	}
+	pagerMu.Lock()
+	defer pagerMu.Unlock()
+	cursorSecret = append([]byte(nil), secret...)
+}
+
+// BindCursorPager 从 query/form/json 中绑定游标分页参数
+func BindCursorPager(c *gin.Context) (p CursorPager, err error) {
+	if err = c.ShouldBind(&p); err != nil {
+		return p, ecode.RequestErr.WithCause(err)
+	}
+	return p.Normalize(), nil
+}
+
+func (p CursorPager) Normalize() CursorPager {
+	p.PageSize = Pager{PageSize: p.PageSize}.Limit()
+	return p
+}
+
+// Limit 数据库查询条数，建议查询 Limit()+1 条来判断是否还有下一页
+func (p CursorPager) Limit() int {
+	return p.Normalize().PageSize
+}
+
+// Keys 解析游标中的排序键到 keys（指针），首页游标为空时返回 false
+func (p CursorPager) Keys(keys any) (ok bool, err error) {
+	if p.Cursor == "" {
+		return false, nil
+	}
+	if err = DecodeCursor(p.Cursor, keys); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Apply next 为下一页起始的排序键，nil 表示没有下一页
+func (p CursorPager) Apply(data any, next any) (any, error) {
+	p = p.Normalize()
+	res := &cursorPageRsp{
+		List: emptyList(data),
+		Page: cursorPage{PageSize: p.PageSize},
+	}
+	if next != nil {
+		token, err := EncodeCursor(next)
+		if err != nil {
+			return nil, err
+		}
+		res.Page.NextCursor = token
+		res.Page.HasMore = true
+	}
+	return res, nil
+}
+
+// EncodeCursor 将排序键编码为签名的游标：base64(json).base64(hmac-sha256)
+func EncodeCursor(keys any) (string, error) {
+	bs, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(bs)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(cursorSign(payload)), nil
+}
+
+// DecodeCursor 校验游标签名并解析排序键到 keys（指针）
+func DecodeCursor(token string, keys any) error {
+	payload, sign, ok := strings.Cut(token, ".")
+	if !ok {
+		return InvalidCursorErr
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sign)
+	if err != nil || !hmac.Equal(sig, cursorSign(payload)) {
+		return InvalidCursorErr
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return InvalidCursorErr
+	}
+	if err = json.Unmarshal(bs, keys); err != nil {
+		return InvalidCursorErr.WithCause(err)
+	}
+	return nil
+}
+
+func newCursorSecret() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}
+
+func cursorSign(payload string) []byte {
+	pagerMu.RLock()
+	secret := cursorSecret
+	pagerMu.RUnlock()
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
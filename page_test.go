@@ -0,0 +1,54 @@
+package web
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPagerApply(t *testing.T) {
+	defSize, maxSize := pageSizeBounds()
+	t.Cleanup(func() { SetPageSize(defSize, maxSize) })
+	SetPageSize(20, 100)
+	p := Pager{PageNo: 0, PageSize: 1_000_000}.Normalize()
+	if p.PageNo != 1 || p.PageSize != 100 {
+		t.Fatalf("Normalize() = %+v", p)
+	}
+	if off := (Pager{PageNo: 3, PageSize: 10}).Offset(); off != 20 {
+		t.Fatalf("Offset() = %d, want 20", off)
+	}
+	var list []string
+	bs, _ := json.Marshal(Pager{PageNo: 1, PageSize: 15}.Apply(31, list))
+	want := `{"list":[],"page":{"total":31,"page_no":1,"page_size":15,"total_pages":3,"has_more":true}}`
+	if string(bs) != want {
+		t.Fatalf("Apply() = %s, want %s", bs, want)
+	}
+}
+
+func TestCursorPager(t *testing.T) {
+	if len(cursorSecret) == 0 {
+		t.Fatal("default cursor secret is empty")
+	}
+	origin := cursorSecret
+	t.Cleanup(func() { cursorSecret = origin })
+	SetCursorSecret([]byte("secret"))
+	type keys struct {
+		Id int64 `json:"id"`
+	}
+	rsp, err := CursorPager{PageSize: 10}.Apply([]int{1, 2}, &keys{Id: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := rsp.(*cursorPageRsp).Page.NextCursor
+	k := &keys{}
+	ok, err := CursorPager{Cursor: next}.Keys(k)
+	if err != nil || !ok || k.Id != 2 {
+		t.Fatalf("Keys() = %v, %v, %+v", ok, err, k)
+	}
+	if err = DecodeCursor(next+"x", k); err == nil {
+		t.Fatal("DecodeCursor() tampered cursor should fail")
+	}
+	SetCursorSecret([]byte("rotated"))
+	if err = DecodeCursor(next, k); err == nil {
+		t.Fatal("DecodeCursor() cursor signed with other secret should fail")
+	}
+}
@@ -1,11 +1,11 @@
 package web
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-pay/ecode"
+	"github.com/go-pay/xlog"
 )
 
 const (
@@ -24,14 +24,20 @@ func JSON(c *gin.Context, data any, err error) {
 		Message: e.Message(),
 		Data:    data,
 	}
+	if v, ok := c.Get(rspTransformerKey); ok {
+		out, err := v.(RspTransformer)(c, rsp)
+		if err != nil {
+			xlog.Errorf("web: transform response error: %v", err)
+			e = ecode.ServerErr
+			c.JSON(http.StatusOK, &CommonRsp{Code: e.Code(), Message: e.Message()})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+		return
+	}
 	c.JSON(http.StatusOK, rsp)
 }
 
 func Redirect(c *gin.Context, location string) {
 	c.Redirect(http.StatusFound, location)
 }
-
-func File(c *gin.Context, filePath, fileName string) {
-	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
-	c.File(filePath)
-}
@@ -0,0 +1,113 @@
+package web
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/web/middleware"
+)
+
+const rspTransformerKey = "web/rsp_transformer"
+
+// RspTransformer 在 JSON 输出前转换统一响应，返回值作为最终的响应体，返回错误时输出 ServerErr
+type RspTransformer func(c *gin.Context, rsp *CommonRsp) (any, error)
+
+// SetRspTransformer 设置当前请求的响应转换，通常在中间件中调用
+func SetRspTransformer(c *gin.Context, fn RspTransformer) {
+	c.Set(rspTransformerKey, fn)
+}
+
+type RspSignConfig struct {
+	InHeader bool `json:"in_header" yaml:"in_header" toml:"in_header"` // 签名放在响应头 X-Sign、X-Sign-Type、X-Timestamp，default 放在响应字段 sign、sign_type、timestamp
+
+	Keys  middleware.SignKeyStore     `json:"-" yaml:"-" toml:"-"` // 必填，通常与 SignVerify 共用
+	AppId func(c *gin.Context) string `json:"-" yaml:"-" toml:"-"` // 获取 app_id，default middleware.SignAppId
+}
+
+// SignedRsp 签名后的统一响应
+type SignedRsp struct {
+	Code        int             `json:"code"`
+	Message     string          `json:"message"`
+	Data        json.RawMessage `json:"data,omitempty"`         // 加密时为密文字符串
+	EncryptType string          `json:"encrypt_type,omitempty"` // 加密方式，未加密时为空
+	SignType    string          `json:"sign_type,omitempty"`
+	Timestamp   string          `json:"timestamp,omitempty"`
+	Sign        string          `json:"sign,omitempty"`
+}
+
+// SignRsp gin middleware，使用 app 的密钥签名 JSON 输出的响应，app 配置了 EncryptType 时先加密 data
+// 待签名字符串为 code、message、data（序列化后的 JSON 或密文）、encrypt_type、timestamp 按 middleware.SortedCanonical 拼接
+// 未获取到 app_id 或 app 不存在时不签名
+func SignRsp(conf *RspSignConfig) gin.HandlerFunc {
+	if conf == nil || conf.Keys == nil {
+		panic("sign rsp: keys is nil")
+	}
+	appIdFn := conf.AppId
+	if appIdFn == nil {
+		appIdFn = middleware.SignAppId
+	}
+	transform := func(c *gin.Context, rsp *CommonRsp) (any, error) {
+		appId := appIdFn(c)
+		if appId == "" {
+			return rsp, nil
+		}
+		key, err := conf.Keys.SignKey(c.Request.Context(), appId)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return rsp, nil
+		}
+		return signRsp(c, key, rsp, conf.InHeader)
+	}
+	return func(c *gin.Context) {
+		SetRspTransformer(c, transform)
+		c.Next()
+	}
+}
+
+func signRsp(c *gin.Context, key *middleware.SignKey, rsp *CommonRsp, inHeader bool) (*SignedRsp, error) {
+	out := &SignedRsp{Code: rsp.Code, Message: rsp.Message}
+	var data string
+	if rsp.Data != nil {
+		bs, err := json.Marshal(rsp.Data)
+		if err != nil {
+			return nil, err
+		}
+		data = string(bs)
+		out.Data = bs
+		if key.EncryptType != "" {
+			if data, err = middleware.EncryptData(key, bs); err != nil {
+				return nil, err
+			}
+			out.Data, _ = json.Marshal(data)
+			out.EncryptType = key.EncryptType
+		}
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	content := middleware.SortedCanonical(map[string]string{
+		"code":         strconv.Itoa(out.Code),
+		"message":      out.Message,
+		"data":         data,
+		"encrypt_type": out.EncryptType,
+		"timestamp":    ts,
+	}, nil)
+	signType := key.SignType
+	if signType == "" {
+		signType = middleware.SignTypeHMACSHA256
+	}
+	sign, err := middleware.SignContent(key, signType, content)
+	if err != nil {
+		return nil, err
+	}
+	if inHeader {
+		c.Header("X-Sign", sign)
+		c.Header("X-Sign-Type", signType)
+		c.Header("X-Timestamp", ts)
+		return out, nil
+	}
+	out.Sign, out.SignType, out.Timestamp = sign, signType, ts
+	return out, nil
+}
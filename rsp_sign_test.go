@@ -0,0 +1,94 @@
+package web
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/web/middleware"
+)
+
+func TestSignRsp(t *testing.T) {
+	platform, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&platform.PublicKey)
+	aesKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	keys := middleware.SignKeyMap{
+		"aes": {Secret: "s1", EncryptType: middleware.EncryptAESGCM, EncryptKey: aesKey},
+		"rsa": {SignType: middleware.SignTypeRSA2, PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(platform)}))},
+	}
+	type order struct {
+		OrderNo string `json:"order_no"`
+		Amount  int    `json:"amount"`
+	}
+	appId := func(c *gin.Context) string { return c.Query("app_id") }
+	g := gin.New()
+	g.GET("/body", SignRsp(&RspSignConfig{Keys: keys, AppId: appId}), func(c *gin.Context) {
+		JSON(c, &order{OrderNo: "o-1", Amount: 100}, nil)
+	})
+	g.GET("/header", SignRsp(&RspSignConfig{Keys: keys, AppId: appId, InHeader: true}), func(c *gin.Context) {
+		JSON(c, &order{OrderNo: "o-2", Amount: 200}, nil)
+	})
+	g.GET("/fail", func(c *gin.Context) {
+		SetRspTransformer(c, func(*gin.Context, *CommonRsp) (any, error) { return nil, errors.New("boom") })
+		JSON(c, "x", nil)
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// AES-GCM 加密 data，HMAC-SHA256 签名
+	rsp := &SignedRsp{}
+	_ = json.Unmarshal(get("/body?app_id=aes").Body.Bytes(), rsp)
+	var ciphertext string
+	if err := json.Unmarshal(rsp.Data, &ciphertext); err != nil || rsp.EncryptType != middleware.EncryptAESGCM {
+		t.Fatalf("encrypted rsp = %+v", rsp)
+	}
+	content := middleware.SortedCanonical(map[string]string{
+		"code": strconv.Itoa(rsp.Code), "message": rsp.Message, "data": ciphertext,
+		"encrypt_type": rsp.EncryptType, "timestamp": rsp.Timestamp,
+	}, nil)
+	if sign, _ := middleware.SignContent(keys["aes"], rsp.SignType, content); sign != rsp.Sign {
+		t.Fatalf("sign = %s, want %s", rsp.Sign, sign)
+	}
+	plaintext, err := middleware.DecryptData(keys["aes"], ciphertext)
+	if err != nil || string(plaintext) != `{"order_no":"o-1","amount":100}` {
+		t.Fatalf("DecryptData() = %s, %v", plaintext, err)
+	}
+
+	// RSA2 签名放在响应头，商户使用平台公钥验签
+	w := get("/header?app_id=rsa")
+	rsp = &SignedRsp{}
+	_ = json.Unmarshal(w.Body.Bytes(), rsp)
+	if rsp.Sign != "" || w.Header().Get("X-Sign-Type") != middleware.SignTypeRSA2 {
+		t.Fatalf("header rsp = %s, %v", w.Body.String(), w.Header())
+	}
+	content = middleware.SortedCanonical(map[string]string{
+		"code": strconv.Itoa(rsp.Code), "message": rsp.Message, "data": string(rsp.Data), "timestamp": w.Header().Get("X-Timestamp"),
+	}, nil)
+	verifier := &middleware.SignKey{PublicKey: base64.StdEncoding.EncodeToString(der)}
+	if err = middleware.VerifyContent(verifier, middleware.SignTypeRSA2, content, w.Header().Get("X-Sign")); err != nil {
+		t.Fatalf("RSA2 Verify() = %v", err)
+	}
+
+	// 未知 app 不签名
+	if body := get("/body?app_id=none").Body.String(); body != `{"code":200,"message":"success","data":{"order_no":"o-1","amount":100}}` {
+		t.Fatalf("unsigned rsp = %s", body)
+	}
+	rsp = &SignedRsp{}
+	_ = json.Unmarshal(get("/fail").Body.Bytes(), rsp)
+	if rsp.Code != 500 {
+		t.Fatalf("transform error rsp = %+v", rsp)
+	}
+}
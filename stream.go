@@ -0,0 +1,226 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TypeEventStream = "text/event-stream"
+	TypeNDJson      = "application/x-ndjson"
+
+	defaultSSEHeartbeat = 15 * time.Second
+	ndjsonFlushLines    = 100
+)
+
+type SSEEvent struct {
+	Id    string        // event id，客户端断线重连时通过 Last-Event-ID 带回
+	Event string        // event name，为空时客户端触发 message 事件
+	Retry time.Duration // 客户端重连间隔，0 不下发
+	Data  any           // string、[]byte 原样输出，其他类型 json 序列化
+}
+
+// SSEReplay 断线重连时的事件回放，由事件发布方写入
+type SSEReplay interface {
+	// Since 返回 lastEventId 之后的事件
+	Since(lastEventId string) []*SSEEvent
+}
+
+type SSEConfig struct {
+	Retry     time.Duration // 连接建立时下发的客户端重连间隔，0 不下发
+	Heartbeat time.Duration // 心跳间隔，default 15s，小于 0 关闭心跳
+	Replay    SSEReplay     // 为空时不处理 Last-Event-ID
+}
+
+// SSE 向客户端推送 events 中的事件，直到 events 被关闭或客户端断开连接
+// events 正常关闭时返回 nil，客户端断开时返回 context 错误
+// 该路由会取消 Server 的 Read/WriteTimeout，长连接不会被超时断开
+func SSE(c *gin.Context, conf *SSEConfig, events <-chan *SSEEvent) (err error) {
+	if conf == nil {
+		conf = &SSEConfig{}
+	}
+	heartbeat := conf.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = defaultSSEHeartbeat
+	}
+	streamHeader(c, TypeEventStream)
+	if conf.Retry > 0 {
+		if _, err = fmt.Fprintf(c.Writer, "retry: %d\n\n", conf.Retry.Milliseconds()); err != nil {
+			return err
+		}
+	}
+	if lastId := c.GetHeader("Last-Event-ID"); lastId != "" && conf.Replay != nil {
+		for _, ev := range conf.Replay.Since(lastId) {
+			if err = writeSSEvent(c, ev); err != nil {
+				return err
+			}
+		}
+	}
+	c.Writer.Flush()
+
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	done := c.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return c.Request.Context().Err()
+		case <-tick:
+			// 注释行作为心跳，客户端忽略，保持连接不被中间代理断开
+			if _, err = c.Writer.WriteString(": ping\n\n"); err != nil {
+				return err
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err = writeSSEvent(c, ev); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeSSEvent(c *gin.Context, ev *SSEEvent) error {
+	if ev == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if ev.Id != "" {
+		buf.WriteString("id: " + sseEscape(ev.Id) + "\n")
+	}
+	if ev.Event != "" {
+		buf.WriteString("event: " + sseEscape(ev.Event) + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString(fmt.Sprintf("retry: %d\n", ev.Retry.Milliseconds()))
+	}
+	var data string
+	switch v := ev.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = string(bs)
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	_, err := c.Writer.Write(buf.Bytes())
+	return err
+}
+
+func sseEscape(s string) string {
+	return strings.NewReplacer("\n", "", "\r", "").Replace(s)
+}
+
+// SSEReplayBuffer 基于内存环形缓冲区的 SSEReplay 实现
+type SSEReplayBuffer struct {
+	mu     sync.RWMutex
+	events []*SSEEvent
+	size   int
+}
+
+// NewSSEReplayBuffer size 为保留的最近事件数量，default 100
+func NewSSEReplayBuffer(size int) *SSEReplayBuffer {
+	if size <= 0 {
+		size = 100
+	}
+	return &SSEReplayBuffer{events: make([]*SSEEvent, 0, size), size: size}
+}
+
+// Add 写入事件，事件需设置 Id 才能被回放
+func (b *SSEReplayBuffer) Add(ev *SSEEvent) {
+	if ev == nil || ev.Id == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.events) == b.size {
+		copy(b.events, b.events[1:])
+		b.events = b.events[:b.size-1]
+	}
+	b.events = append(b.events, ev)
+}
+
+// Since lastEventId 不在缓冲区中（过旧或未知）时返回全部缓冲事件
+func (b *SSEReplayBuffer) Since(lastEventId string) []*SSEEvent {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	start := 0
+	for i := len(b.events) - 1; i >= 0; i-- {
+		if b.events[i].Id == lastEventId {
+			start = i + 1
+			break
+		}
+	}
+	res := make([]*SSEEvent, len(b.events)-start)
+	copy(res, b.events[start:])
+	return res
+}
+
+// ============================================================================================================
+
+// NDJSONWriter 按行输出 json（application/x-ndjson），用于大数据量导出
+type NDJSONWriter struct {
+	c     *gin.Context
+	enc   *json.Encoder
+	lines int
+}
+
+// NDJSON 开始 NDJSON 流式响应，该路由会取消 Server 的 Read/WriteTimeout
+func NDJSON(c *gin.Context) *NDJSONWriter {
+	streamHeader(c, TypeNDJson)
+	return &NDJSONWriter{c: c, enc: json.NewEncoder(c.Writer)}
+}
+
+// Write 写入一行，客户端断开后返回 context 错误
+func (w *NDJSONWriter) Write(v any) error {
+	if err := w.c.Request.Context().Err(); err != nil {
+		return err
+	}
+	if err := w.enc.Encode(v); err != nil {
+		return err
+	}
+	w.lines++
+	if w.lines%ndjsonFlushLines == 0 {
+		w.Flush()
+	}
+	return nil
+}
+
+func (w *NDJSONWriter) Flush() {
+	w.c.Writer.Flush()
+}
+
+func streamHeader(c *gin.Context, contentType string) {
+	rc := http.NewResponseController(c.Writer)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	h := c.Writer.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "no-cache")
+	// 关闭 nginx 代理缓冲
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/web/middleware"
+)
+
+func TestSSE(t *testing.T) {
+	replay := NewSSEReplayBuffer(10)
+	for _, id := range []string{"1", "2", "3"} {
+		replay.Add(&SSEEvent{Id: id, Data: "replay-" + id})
+	}
+	g := gin.New()
+	g.GET("/sse", middleware.AccessLog("test"), func(c *gin.Context) {
+		ch := make(chan *SSEEvent)
+		go func() {
+			// 超过 WriteTimeout 后继续推送
+			time.Sleep(300 * time.Millisecond)
+			ch <- &SSEEvent{Id: "4", Event: "status", Data: map[string]string{"status": "paid"}}
+			close(ch)
+		}()
+		_ = SSE(c, &SSEConfig{Replay: replay}, ch)
+	})
+	srv := httptest.NewUnstartedServer(g)
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/sse", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var lines []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if sc.Text() != "" {
+			lines = append(lines, sc.Text())
+		}
+	}
+	got := strings.Join(lines, "|")
+	want := `id: 3|data: replay-3|id: 4|event: status|data: {"status":"paid"}`
+	if got != want {
+		t.Fatalf("SSE got %q, want %q", got, want)
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/sse", nil)
+	ch := make(chan *SSEEvent)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(ch)
+	}()
+	if err := SSE(c, &SSEConfig{Heartbeat: 20 * time.Millisecond}, ch); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(w.Body.String(), ": ping\n\n") || w.Header().Get("Connection") != "" {
+		t.Fatalf("heartbeat: %q %v", w.Body.String(), w.Header())
+	}
+}
+
+func TestNDJSONFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/export", nil)
+	nw := NDJSON(c)
+	for i := 1; i < ndjsonFlushLines; i++ {
+		if err := nw.Write(map[string]int{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Flushed {
+		t.Fatal("flushed before ndjsonFlushLines")
+	}
+	// 每 ndjsonFlushLines 行刷新一次
+	if err := nw.Write(map[string]int{"id": ndjsonFlushLines}); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Flushed {
+		t.Fatal("not flushed after ndjsonFlushLines")
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	var last map[string]int
+	if len(lines) != ndjsonFlushLines || json.Unmarshal([]byte(lines[len(lines)-1]), &last) != nil || last["id"] != ndjsonFlushLines {
+		t.Fatalf("ndjson lines = %d, last %q", len(lines), lines[len(lines)-1])
+	}
+	if ct := w.Header().Get("Content-Type"); ct != TypeNDJson {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+}
@@ -0,0 +1,266 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+)
+
+const (
+	defaultUploadMaxFileSize  = 10 << 20
+	defaultUploadMaxTotalSize = 32 << 20
+	defaultUploadMaxFiles     = 10
+	uploadMaxValueSize        = 1 << 20
+)
+
+var (
+	UploadInvalidErr        = ecode.New(400, "INVALID_MULTIPART", "invalid multipart request")
+	UploadNoFileErr         = ecode.New(400, "NO_FILE", "no file uploaded")
+	UploadTooManyFilesErr   = ecode.New(400, "TOO_MANY_FILES", "too many files")
+	UploadFileTooLargeErr   = ecode.New(413, "FILE_TOO_LARGE", "file too large")
+	UploadTooLargeErr       = ecode.New(413, "REQUEST_TOO_LARGE", "request body too large")
+	UploadTypeNotAllowedErr = ecode.New(415, "FILE_TYPE_NOT_ALLOWED", "file type not allowed")
+	UploadStorageErr        = ecode.New(500, "STORAGE_ERROR", "file storage error")
+)
+
+type UploadConfig struct {
+	MaxFileSize  int64                        // 单个文件最大字节数，default 10MB
+	MaxTotalSize int64                        // 所有文件和表单字段总字节数，default 32MB
+	MaxFiles     int                          // 最大文件数，default 10
+	AllowedTypes []string                     // 允许的 MIME 类型（按内容嗅探，不信任客户端 Content-Type），支持 image/*，为空不限制
+	Storage      UploadStorage                // 文件存储，必填
+	KeyFunc      func(f *UploadedFile) string // 生成存储 key，default 20060102/随机串.扩展名
+}
+
+type UploadedFile struct {
+	Field       string `json:"field"`
+	FileName    string `json:"file_name"` // 清理后的原始文件名
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Sha256      string `json:"sha256"`
+}
+
+type UploadResult struct {
+	Files  []*UploadedFile     `json:"files"`
+	Values map[string][]string `json:"values"` // 非文件表单字段
+}
+
+// Upload 流式读取 multipart 请求，边读边校验大小、嗅探类型、计算 sha256 并写入 Storage
+// 任一文件失败时删除本次已保存的文件，返回的 error 为 ecode 错误，可直接 web.JSON 输出
+func Upload(c *gin.Context, conf *UploadConfig) (res *UploadResult, err error) {
+	if conf == nil || conf.Storage == nil {
+		return nil, ecode.ServerErr.WithCause(errors.New("upload storage is nil"))
+	}
+	var (
+		maxFile  = conf.MaxFileSize
+		maxTotal = conf.MaxTotalSize
+		maxFiles = conf.MaxFiles
+		ctx      = c.Request.Context()
+	)
+	if maxFile <= 0 {
+		maxFile = defaultUploadMaxFileSize
+	}
+	if maxTotal <= 0 {
+		maxTotal = defaultUploadMaxTotalSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultUploadMaxFiles
+	}
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, UploadInvalidErr.WithCause(err)
+	}
+	res = &UploadResult{Values: make(map[string][]string)}
+	defer func() {
+		if err != nil {
+			for _, f := range res.Files {
+				_ = conf.Storage.Delete(context.WithoutCancel(ctx), f.Key)
+			}
+			res = nil
+		}
+	}()
+	total := &sizeCounter{max: maxTotal, err: UploadTooLargeErr}
+	for {
+		part, e := mr.NextPart()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return res, uploadReadErr(total, nil, e)
+		}
+		field := part.FormName()
+		if part.FileName() == "" {
+			// 普通表单字段
+			bs, e := io.ReadAll(io.LimitReader(&countReader{r: part, counters: []*sizeCounter{total}}, uploadMaxValueSize+1))
+			if e != nil {
+				return res, uploadReadErr(total, nil, e)
+			}
+			if len(bs) > uploadMaxValueSize {
+				return res, UploadTooLargeErr
+			}
+			res.Values[field] = append(res.Values[field], string(bs))
+			continue
+		}
+		if len(res.Files) >= maxFiles {
+			return res, UploadTooManyFilesErr
+		}
+		f, e := saveUploadPart(ctx, conf, part, total, maxFile)
+		if e != nil {
+			return res, e
+		}
+		res.Files = append(res.Files, f)
+	}
+	if len(res.Files) == 0 {
+		return res, UploadNoFileErr
+	}
+	return res, nil
+}
+
+func saveUploadPart(ctx context.Context, conf *UploadConfig, part *multipart.Part, total *sizeCounter, maxFile int64) (*UploadedFile, error) {
+	fileCounter := &sizeCounter{max: maxFile, err: UploadFileTooLargeErr}
+	h := sha256.New()
+	r := &countReader{r: part, counters: []*sizeCounter{total, fileCounter}}
+	// 读取前 512 字节嗅探真实类型
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, uploadReadErr(total, fileCounter, err)
+	}
+	head = head[:n]
+	ctype := http.DetectContentType(head)
+	if !allowedType(conf.AllowedTypes, ctype) {
+		return nil, UploadTypeNotAllowedErr
+	}
+	f := &UploadedFile{
+		Field:       part.FormName(),
+		FileName:    SanitizeFileName(part.FileName()),
+		ContentType: ctype,
+	}
+	if conf.KeyFunc != nil {
+		f.Key = conf.KeyFunc(f)
+	} else {
+		f.Key = defaultUploadKey(f)
+	}
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), r), h)
+	if err = conf.Storage.Save(ctx, f.Key, ctype, body); err != nil {
+		_ = conf.Storage.Delete(context.WithoutCancel(ctx), f.Key)
+		return nil, uploadReadErr(total, fileCounter, err)
+	}
+	f.Size = fileCounter.n
+	f.Sha256 = hex.EncodeToString(h.Sum(nil))
+	return f, nil
+}
+
+// SanitizeFileName 清理客户端上传的文件名：去掉路径、控制字符和保留字符，长度限制 255 字节
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+func allowedType(allowed []string, ctype string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, a[:len(a)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultUploadKey(f *UploadedFile) string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	ext := strings.ToLower(filepath.Ext(f.FileName))
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(f.ContentType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return time.Now().Format("20060102") + "/" + hex.EncodeToString(b) + ext
+}
+
+// uploadReadErr 区分超出大小限制和其他读取、存储错误
+func uploadReadErr(total, file *sizeCounter, err error) error {
+	var e *ecode.Error
+	switch {
+	case file != nil && file.exceeded():
+		return file.err
+	case total.exceeded():
+		return total.err
+	case errors.As(err, &e):
+		return err
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return UploadInvalidErr.WithCause(err)
+	}
+	return UploadStorageErr.WithCause(err)
+}
+
+type sizeCounter struct {
+	n   int64
+	max int64
+	err *ecode.Error
+}
+
+func (s *sizeCounter) exceeded() bool {
+	return s.n > s.max
+}
+
+// countReader 读取时累加计数，任一计数超过上限时返回对应错误
+type countReader struct {
+	r        io.Reader
+	counters []*sizeCounter
+}
+
+func (c *countReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	for _, s := range c.counters {
+		s.n += int64(n)
+	}
+	for _, s := range c.counters {
+		if s.exceeded() {
+			return n, s.err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n0000IHDR")
+
+func uploadRequest(t *testing.T, files map[string][]byte) *http.Request {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	_ = w.WriteField("order_no", "GP2024")
+	for name, data := range files {
+		// 客户端声明的 Content-Type 不可信，服务端按内容嗅探
+		fw, err := w.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = fw.Write(data)
+	}
+	_ = w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestUpload(t *testing.T) {
+	store := NewMemoryStorage()
+	conf := &UploadConfig{MaxFileSize: 64, AllowedTypes: []string{"image/*"}, Storage: store}
+	var (
+		res *UploadResult
+		err error
+	)
+	g := gin.New()
+	g.POST("/upload", func(c *gin.Context) {
+		res, err = Upload(c, conf)
+	})
+
+	g.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, map[string][]byte{"../../头像.png": pngHeader}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := res.Files[0]
+	if f.FileName != "头像.png" || f.ContentType != "image/png" || f.Size != int64(len(pngHeader)) || res.Values["order_no"][0] != "GP2024" {
+		t.Fatalf("Upload() = %+v", f)
+	}
+	if bs, ok := store.Get(f.Key); !ok || !bytes.Equal(bs, pngHeader) || f.Sha256 != sha256Hex(pngHeader) {
+		t.Fatalf("stored %q, sha256 %s", bs, f.Sha256)
+	}
+
+	g.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, map[string][]byte{"a.png": []byte("<html>fake</html>")}))
+	if !UploadTypeNotAllowedErr.Is(err) {
+		t.Fatalf("Upload() html err = %v", err)
+	}
+
+	g.ServeHTTP(httptest.NewRecorder(), uploadRequest(t, map[string][]byte{"big.png": append(pngHeader, make([]byte, 64)...)}))
+	if !UploadFileTooLargeErr.Is(err) {
+		t.Fatalf("Upload() big file err = %v", err)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/") ||
+			r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.EscapedPath()], _ = io.ReadAll(r.Body)
+		case http.MethodDelete:
+			delete(objects, r.URL.EscapedPath())
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	s := &S3Storage{Endpoint: srv.URL, Bucket: "bills", AccessKey: "ak", SecretKey: "sk"}
+	ctx := httptest.NewRequest(http.MethodGet, "/", nil).Context()
+	if err := s.Save(ctx, "2024/账单.csv", "text/csv", strings.NewReader("a,b")); err != nil {
+		t.Fatal(err)
+	}
+	bs, _ := json.Marshal(objects)
+	if string(bs) != `{"/bills/2024/%E8%B4%A6%E5%8D%95.csv":"YSxi"}` {
+		t.Fatalf("objects = %s", bs)
+	}
+	if err := s.Delete(ctx, "2024/账单.csv"); err != nil || len(objects) != 0 {
+		t.Fatalf("Delete() err = %v, objects = %v", err, objects)
+	}
+}
@@ -0,0 +1,385 @@
+package web
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/web/metadata"
+	"github.com/go-pay/web/middleware"
+	"github.com/go-pay/xlog"
+)
+
+const (
+	defaultWebhookWorkers   = 8
+	defaultWebhookQueueSize = 1024
+	defaultWebhookDedupTTL  = 24 * time.Hour
+	defaultWebhookTimeout   = 30 * time.Second
+)
+
+var (
+	WebhookVerifyErr     = ecode.New(http.StatusUnauthorized, "WEBHOOK_VERIFY_FAILED", "webhook verify failed")
+	WebhookInProgressErr = ecode.New(http.StatusConflict, "WEBHOOK_IN_PROGRESS", "webhook event is processing")
+	WebhookBusyErr       = ecode.New(http.StatusServiceUnavailable, "WEBHOOK_BUSY", "webhook queue is full")
+
+	// AlipayReply 支付宝异步通知应答，成功返回 success，其他内容渠道会重试
+	AlipayReply = TextReply("success", "fail")
+	// WechatV3Reply 微信支付 v3 通知应答，失败时返回 500 和 {"code":"FAIL","message":"..."}
+	WechatV3Reply WebhookReply = func(c *gin.Context, err error) {
+		if err == nil {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": "FAIL", "message": ecode.FromError(err).Message()})
+	}
+	// WechatV2Reply 微信支付 v2 通知应答，XML 格式的 return_code、return_msg
+	WechatV2Reply WebhookReply = func(c *gin.Context, err error) {
+		rsp := &wechatV2Rsp{ReturnCode: "SUCCESS", ReturnMsg: "OK"}
+		if err != nil {
+			rsp = &wechatV2Rsp{ReturnCode: "FAIL", ReturnMsg: ecode.FromError(err).Message()}
+		}
+		c.XML(http.StatusOK, rsp)
+	}
+)
+
+// WebhookVerifier 校验回调来源，例如渠道的签名、证书序列号
+type WebhookVerifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+// WebhookVerifierFunc 函数形式的 WebhookVerifier
+type WebhookVerifierFunc func(r *http.Request, body []byte) error
+
+func (f WebhookVerifierFunc) Verify(r *http.Request, body []byte) error {
+	return f(r, body)
+}
+
+// WebhookReply 渠道要求的应答格式，err 为 nil 表示处理成功
+type WebhookReply func(c *gin.Context, err error)
+
+// WebhookHandler 处理回调事件，返回错误时按渠道格式应答失败，渠道会重试
+type WebhookHandler func(ctx context.Context, e *WebhookEvent) error
+
+// WebhookEvent 已校验的回调事件
+type WebhookEvent struct {
+	Provider   string
+	Id         string // 事件 id，为空表示不去重
+	Header     http.Header
+	Body       []byte
+	ReceivedAt time.Time
+
+	dedupToken string
+}
+
+type WebhookConfig struct {
+	Provider string                                             // 渠道名，用于去重 key 和日志
+	Verifier WebhookVerifier                                    // 为空不校验
+	EventId  func(r *http.Request, body []byte) (string, error) // 提取事件 id，为空不去重
+	Reply    WebhookReply                                       // 应答格式，default 使用 JSON 统一响应结构
+
+	Async     bool                        // 校验、去重后立即应答成功，事件交给后台 worker 处理，处理失败只记录日志
+	Workers   int                         // Async 时的 worker 数，default 8
+	QueueSize int                         // Async 时的队列长度，队列满时应答失败由渠道重试，default 1024
+	Timeout   time.Duration               // 单个事件的处理超时，default 30s
+	LockTTL   time.Duration               // 处理中去重记录的过期时间，进程异常退出后渠道重试可在该时间后重新处理，default 2 × Timeout
+	DedupTTL  time.Duration               // 处理成功的去重记录保存时长，default 24h
+	Dedup     middleware.IdempotencyStore // 去重存储，default 进程内存储，多实例部署请使用 RedisIdempotencyStore
+}
+
+type WebhookStats struct {
+	Received   int64 `json:"received"`   // 校验通过的事件数
+	Duplicated int64 `json:"duplicated"` // 已处理过直接应答成功的重复事件数
+	Failed     int64 `json:"failed"`     // 处理失败的事件数
+	Queued     int64 `json:"queued"`     // Async 时队列中等待处理的事件数
+}
+
+type wechatV2Rsp struct {
+	XMLName    xml.Name `xml:"xml"`
+	ReturnCode string   `xml:"return_code"`
+	ReturnMsg  string   `xml:"return_msg"`
+}
+
+// TextReply 纯文本应答，HTTP 状态码均为 200
+func TextReply(success, failure string) WebhookReply {
+	return func(c *gin.Context, err error) {
+		if err == nil {
+			c.String(http.StatusOK, success)
+			return
+		}
+		c.String(http.StatusOK, failure)
+	}
+}
+
+// Webhook 异步通知路由：校验来源、按事件 id 去重、处理并按渠道格式应答
+// 已处理成功的重复事件直接应答成功，处理中的重复事件应答失败由渠道稍后重试；GinEngine 关闭时等待队列中的事件处理完成
+func (g *GinEngine) Webhook(conf *WebhookConfig, handler WebhookHandler) gin.HandlerFunc {
+	// 复制一份，默认值不写回调用方的配置
+	c := WebhookConfig{}
+	if conf != nil {
+		c = *conf
+	}
+	conf = &c
+	reply := conf.Reply
+	if reply == nil {
+		reply = func(c *gin.Context, err error) { JSON(c, nil, err) }
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultWebhookTimeout
+	}
+	if conf.LockTTL <= 0 {
+		conf.LockTTL = 2 * conf.Timeout
+	}
+	if conf.DedupTTL <= 0 {
+		conf.DedupTTL = defaultWebhookDedupTTL
+	}
+	if conf.Dedup == nil {
+		conf.Dedup = middleware.NewMemoryIdempotencyStore()
+	}
+	if conf.Workers <= 0 {
+		conf.Workers = defaultWebhookWorkers
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultWebhookQueueSize
+	}
+	w := &webhook{conf: conf, handler: handler}
+	if conf.Async {
+		w.pool = g.wh.newPool(conf.Provider, conf.Workers, conf.QueueSize, w.process)
+	}
+	g.wh.add(w)
+	return func(c *gin.Context) {
+		reply(c, w.receive(c))
+	}
+}
+
+// WebhookStats 按渠道统计回调事件
+func (g *GinEngine) WebhookStats() map[string]WebhookStats {
+	return g.wh.stats()
+}
+
+type webhook struct {
+	conf    *WebhookConfig
+	handler WebhookHandler
+	pool    *webhookPool
+
+	received   atomic.Int64
+	duplicated atomic.Int64
+	failed     atomic.Int64
+}
+
+func (w *webhook) receive(c *gin.Context) error {
+	var body []byte
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		var err error
+		if body, err = metadata.RequestBody(c.Request); err != nil {
+			return ecode.RequestErr.WithCause(err)
+		}
+	}
+	if w.conf.Verifier != nil {
+		if err := w.conf.Verifier.Verify(c.Request, body); err != nil {
+			xlog.Warnf("webhook(%s) verify error: %v", w.conf.Provider, err)
+			return WebhookVerifyErr.WithCause(err)
+		}
+	}
+	e := &WebhookEvent{Provider: w.conf.Provider, Header: c.Request.Header.Clone(), Body: body, ReceivedAt: time.Now()}
+	if w.conf.EventId != nil {
+		id, err := w.conf.EventId(c.Request, body)
+		if err != nil {
+			return ecode.RequestErr.WithCause(err)
+		}
+		e.Id = id
+	}
+	w.received.Add(1)
+
+	// 客户端断开不影响去重记录的写入
+	ctx := context.WithoutCancel(c.Request.Context())
+	if e.Id != "" {
+		e.dedupToken = middleware.NewIdempotencyToken()
+		existing, locked, err := w.conf.Dedup.Lock(ctx, w.dedupKey(e), w.lockRecord(e), w.conf.LockTTL)
+		if err != nil {
+			return ecode.ServerErr.WithCause(err)
+		}
+		if !locked {
+			if existing.Completed {
+				w.duplicated.Add(1)
+				return nil
+			}
+			return WebhookInProgressErr
+		}
+	}
+	if w.pool != nil {
+		if !w.pool.submit(e) {
+			w.release(ctx, e)
+			return WebhookBusyErr
+		}
+		return nil
+	}
+	return w.process(ctx, e)
+}
+
+// process 处理事件，成功后标记去重记录已完成，失败时删除记录允许渠道重试
+func (w *webhook) process(ctx context.Context, e *WebhookEvent) (err error) {
+	if w.pool != nil && e.Id != "" {
+		// 事件在队列中等待后重新计算处理中记录的过期时间，记录已过期并被重试的事件重新锁定时不再处理
+		if err = w.conf.Dedup.Save(ctx, w.dedupKey(e), w.lockRecord(e), w.conf.LockTTL); err != nil {
+			w.failed.Add(1)
+			xlog.Errorf("webhook(%s) event(%s) renew dedup error: %v", w.conf.Provider, e.Id, err)
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.conf.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("webhook handler panic: %v", r)
+		}
+		if err != nil {
+			w.failed.Add(1)
+			xlog.Errorf("webhook(%s) event(%s) process error: %v", w.conf.Provider, e.Id, err)
+			w.release(context.WithoutCancel(ctx), e)
+			return
+		}
+		if e.Id != "" {
+			rec := &middleware.IdempotencyRecord{Token: e.dedupToken, RequestHash: e.Id, Completed: true, CreatedAt: time.Now()}
+			if serr := w.conf.Dedup.Save(context.WithoutCancel(ctx), w.dedupKey(e), rec, w.conf.DedupTTL); serr != nil {
+				xlog.Warnf("webhook(%s) event(%s) save dedup error: %v", w.conf.Provider, e.Id, serr)
+			}
+		}
+	}()
+	return w.handler(ctx, e)
+}
+
+func (w *webhook) release(ctx context.Context, e *WebhookEvent) {
+	if e.Id == "" {
+		return
+	}
+	if err := w.conf.Dedup.Delete(ctx, w.dedupKey(e), e.dedupToken); err != nil {
+		xlog.Warnf("webhook(%s) event(%s) delete dedup error: %v", w.conf.Provider, e.Id, err)
+	}
+}
+
+func (w *webhook) lockRecord(e *WebhookEvent) *middleware.IdempotencyRecord {
+	return &middleware.IdempotencyRecord{Token: e.dedupToken, RequestHash: e.Id, CreatedAt: e.ReceivedAt}
+}
+
+func (w *webhook) dedupKey(e *WebhookEvent) string {
+	return "web:webhook:" + w.conf.Provider + ":" + e.Id
+}
+
+// ============================================================================================================
+
+type webhookHub struct {
+	mu       sync.Mutex
+	webhooks []*webhook
+	pools    []*webhookPool
+}
+
+func newWebhookHub() *webhookHub {
+	return &webhookHub{}
+}
+
+func (h *webhookHub) add(w *webhook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.webhooks = append(h.webhooks, w)
+}
+
+func (h *webhookHub) newPool(name string, workers, size int, process func(ctx context.Context, e *WebhookEvent) error) *webhookPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &webhookPool{name: name, queue: make(chan *WebhookEvent, size), ctx: ctx, cancel: cancel}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for e := range p.queue {
+				_ = process(p.ctx, e)
+			}
+		}()
+	}
+	h.mu.Lock()
+	h.pools = append(h.pools, p)
+	h.mu.Unlock()
+	return p
+}
+
+func (h *webhookHub) stats() map[string]WebhookStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m := make(map[string]WebhookStats, len(h.webhooks))
+	for _, w := range h.webhooks {
+		s := m[w.conf.Provider]
+		s.Received += w.received.Load()
+		s.Duplicated += w.duplicated.Load()
+		s.Failed += w.failed.Load()
+		if w.pool != nil {
+			s.Queued += int64(len(w.pool.queue))
+		}
+		m[w.conf.Provider] = s
+	}
+	return m
+}
+
+// shutdown 停止接收新事件，等待队列中的事件处理完成，ctx 超时后取消处理中事件的 context
+func (h *webhookHub) shutdown(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	pools := h.pools
+	h.mu.Unlock()
+	for _, p := range pools {
+		p.close()
+	}
+	for _, p := range pools {
+		done := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			xlog.Warnf("webhook(%s) shutdown timeout, %d events dropped", p.name, len(p.queue))
+			p.cancel()
+		}
+	}
+}
+
+type webhookPool struct {
+	name   string
+	queue  chan *WebhookEvent
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (p *webhookPool) submit(e *WebhookEvent) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.queue <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *webhookPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-pay/web/middleware"
+)
+
+func TestWebhook(t *testing.T) {
+	g := InitGin(&Config{Addr: ":0"})
+	g.timeout = 2 * time.Second
+	var (
+		mu        sync.Mutex
+		processed []string
+		fail      atomic.Bool
+	)
+	eventId := func(r *http.Request, body []byte) (string, error) {
+		var e struct {
+			Id string `json:"id"`
+		}
+		return e.Id, json.Unmarshal(body, &e)
+	}
+	verifier := WebhookVerifierFunc(func(r *http.Request, body []byte) error {
+		if r.Header.Get("X-Signature") != "ok" {
+			return errors.New("bad signature")
+		}
+		return nil
+	})
+	g.Gin.POST("/notify/alipay", g.Webhook(&WebhookConfig{Provider: "alipay", Verifier: verifier, EventId: eventId, Reply: AlipayReply},
+		func(ctx context.Context, e *WebhookEvent) error {
+			if fail.Load() {
+				return errors.New("db down")
+			}
+			mu.Lock()
+			processed = append(processed, e.Id)
+			mu.Unlock()
+			return nil
+		}))
+	release := make(chan struct{})
+	g.Gin.POST("/notify/wechat", g.Webhook(&WebhookConfig{Provider: "wechat", EventId: eventId, Reply: WechatV3Reply, Async: true, Workers: 1, QueueSize: 1},
+		func(ctx context.Context, e *WebhookEvent) error {
+			<-release
+			mu.Lock()
+			processed = append(processed, e.Id)
+			mu.Unlock()
+			return nil
+		}))
+	g.Gin.POST("/notify/v2", g.Webhook(&WebhookConfig{Provider: "v2", Reply: WechatV2Reply}, func(context.Context, *WebhookEvent) error {
+		return errors.New("fail")
+	}))
+	post := func(path, body, sig string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("X-Signature", sig)
+		g.Gin.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("/notify/alipay", `{"id":"e1"}`, "bad"); w.Body.String() != "fail" {
+		t.Fatalf("bad signature = %s", w.Body.String())
+	}
+	// 处理失败后渠道重试可再次处理
+	fail.Store(true)
+	if w := post("/notify/alipay", `{"id":"e1"}`, "ok"); w.Body.String() != "fail" {
+		t.Fatalf("handler error = %s", w.Body.String())
+	}
+	fail.Store(false)
+	for i := 0; i < 2; i++ {
+		if w := post("/notify/alipay", `{"id":"e1"}`, "ok"); w.Body.String() != "success" {
+			t.Fatalf("notify %d = %s", i, w.Body.String())
+		}
+	}
+	if st := g.WebhookStats()["alipay"]; st.Received != 3 || st.Duplicated != 1 || st.Failed != 1 || len(processed) != 1 {
+		t.Fatalf("WebhookStats() = %+v, processed %v", st, processed)
+	}
+	if w := post("/notify/v2", `{}`, ""); !strings.Contains(w.Body.String(), "<return_code>FAIL</return_code>") {
+		t.Fatalf("wechat v2 reply = %s", w.Body.String())
+	}
+
+	// 异步：先应答，处理中的重复事件应答失败，队列满时应答失败
+	if w := post("/notify/wechat", `{"id":"w1"}`, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("async notify = %d", w.Code)
+	}
+	if w := post("/notify/wechat", `{"id":"w1"}`, ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("async in-progress duplicate = %d", w.Code)
+	}
+	deadline := time.Now().Add(time.Second)
+	for g.WebhookStats()["wechat"].Queued != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	post("/notify/wechat", `{"id":"w2"}`, "")
+	if w := post("/notify/wechat", `{"id":"w3"}`, ""); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), WebhookBusyErr.Message()) {
+		t.Fatalf("async queue full = %d %s", w.Code, w.Body.String())
+	}
+
+	// 关闭时等待队列中的事件处理完成
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	g.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(processed, ",") != "e1,w1,w2" {
+		t.Fatalf("processed = %v", processed)
+	}
+	if w := post("/notify/wechat", `{"id":"w4"}`, ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("notify after close = %d", w.Code)
+	}
+}
+
+func TestWebhookLockTTL(t *testing.T) {
+	g := InitGin(&Config{Addr: ":0"})
+	defer g.Close()
+	store := middleware.NewMemoryIdempotencyStore()
+	conf := &WebhookConfig{Provider: "alipay", Reply: AlipayReply, Dedup: store, LockTTL: 50 * time.Millisecond,
+		EventId: func(*http.Request, []byte) (string, error) { return "e1", nil }}
+	var processed atomic.Int32
+	g.Gin.POST("/notify", g.Webhook(conf, func(context.Context, *WebhookEvent) error {
+		processed.Add(1)
+		return nil
+	}))
+	if conf.Timeout != 0 || conf.DedupTTL != 0 {
+		t.Fatalf("Webhook() wrote defaults back to config: %+v", conf)
+	}
+	post := func() string {
+		w := httptest.NewRecorder()
+		g.Gin.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader("{}")))
+		return w.Body.String()
+	}
+
+	// 模拟进程在处理中退出，遗留的处理中记录在 LockTTL 后过期
+	_, _, _ = store.Lock(context.Background(), "web:webhook:alipay:e1", &middleware.IdempotencyRecord{Token: "crashed", RequestHash: "e1"}, conf.LockTTL)
+	if body := post(); body != "fail" {
+		t.Fatalf("in-progress duplicate = %s", body)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if body := post(); body != "success" || processed.Load() != 1 {
+		t.Fatalf("retry after lock ttl = %s, processed %d", body, processed.Load())
+	}
+	// 处理成功的记录按 DedupTTL 保存
+	time.Sleep(80 * time.Millisecond)
+	if body := post(); body != "success" || processed.Load() != 1 {
+		t.Fatalf("duplicate after completed = %s, processed %d", body, processed.Load())
+	}
+}
@@ -0,0 +1,297 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/ecode"
+	"github.com/go-pay/limiter/rate"
+	"github.com/go-pay/xlog"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultWSPingInterval   = 30 * time.Second
+	defaultWSWriteWait      = 10 * time.Second
+	defaultWSMaxMessageSize = 64 << 10
+)
+
+var ErrWSRateLimited = errors.New("websocket: message rate limit exceeded")
+
+type WebSocketConfig struct {
+	CheckOrigin    func(r *http.Request) bool // 为空时使用 Config.CORS 校验 Origin，未配置 allow_origins 时仅允许同源
+	PingInterval   time.Duration              // ping 间隔，default 30s，超过 2 个间隔未收到 pong 断开连接
+	WriteWait      time.Duration              // 单次写超时，default 10s
+	MaxMessageSize int64                      // 单条消息最大字节数，default 64KB
+	Rate           int                        // 每个连接每秒可接收的消息数，0 不限流
+	Burst          int                        // 限流桶大小，default Rate
+}
+
+type WebSocketHandler func(c *gin.Context, conn *WSConn)
+
+type WebSocketStats struct {
+	Active   int64 `json:"active"`   // 当前连接数
+	Total    int64 `json:"total"`    // 累计连接数
+	Rejected int64 `json:"rejected"` // 握手失败或关闭中拒绝的连接数
+}
+
+// WSConn 并发安全的写操作封装，读操作只允许在一个 goroutine 中调用
+type WSConn struct {
+	conn      *websocket.Conn
+	writeMu   sync.Mutex
+	writeWait time.Duration
+	limiter   *rate.Limiter
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// WebSocket websocket 路由，handler 返回后连接关闭，GinEngine 关闭时向客户端发送 close frame 并等待连接退出
+func (g *GinEngine) WebSocket(conf *WebSocketConfig, handler WebSocketHandler) gin.HandlerFunc {
+	if conf == nil {
+		conf = &WebSocketConfig{}
+	}
+	if conf.PingInterval <= 0 {
+		conf.PingInterval = defaultWSPingInterval
+	}
+	if conf.WriteWait <= 0 {
+		conf.WriteWait = defaultWSWriteWait
+	}
+	if conf.MaxMessageSize <= 0 {
+		conf.MaxMessageSize = defaultWSMaxMessageSize
+	}
+	if conf.Rate > 0 && conf.Burst <= 0 {
+		conf.Burst = conf.Rate
+	}
+	checkOrigin := conf.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			if g.cors == nil || len(g.cors.AllowOrigins) == 0 {
+				// 防止跨站页面携带 cookie 建立连接
+				u, err := url.Parse(origin)
+				return err == nil && strings.EqualFold(u.Host, r.Host)
+			}
+			return g.cors.AllowOrigin(origin)
+		}
+	}
+	upgrader := &websocket.Upgrader{
+		HandshakeTimeout: conf.WriteWait,
+		CheckOrigin:      checkOrigin,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			atomic.AddInt64(&g.ws.rejected, 1)
+			http.Error(w, http.StatusText(status), status)
+		},
+	}
+	return func(c *gin.Context) {
+		if g.ws.isClosing() {
+			atomic.AddInt64(&g.ws.rejected, 1)
+			JSON(c, nil, ecode.ServiceUnavailableErr)
+			c.Abort()
+			return
+		}
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			xlog.Warnf("websocket upgrade error: %v", err)
+			c.Abort()
+			return
+		}
+		ws := &WSConn{conn: conn, writeWait: conf.WriteWait, done: make(chan struct{})}
+		if conf.Rate > 0 {
+			ws.limiter = rate.NewLimiter(rate.Limit(conf.Rate), conf.Burst)
+		}
+		if !g.ws.add(ws) {
+			_ = ws.CloseWith(websocket.CloseGoingAway, "server shutting down")
+			return
+		}
+		defer g.ws.remove(ws)
+		defer ws.Close()
+
+		conn.SetReadLimit(conf.MaxMessageSize)
+		pongWait := conf.PingInterval * 2
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		go ws.keepalive(conf.PingInterval)
+		handler(c, ws)
+	}
+}
+
+// WebSocketStats websocket 连接数统计
+func (g *GinEngine) WebSocketStats() WebSocketStats {
+	return g.ws.stats()
+}
+
+// ReadMessage 读取一条消息，超过限流时发送 close frame 并返回 ErrWSRateLimited
+func (w *WSConn) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, p, err = w.conn.ReadMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	if w.limiter != nil && !w.limiter.Allow() {
+		_ = w.CloseWith(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return 0, nil, ErrWSRateLimited
+	}
+	return messageType, p, nil
+}
+
+// ReadJSON 读取一条消息并 json 反序列化到 v
+func (w *WSConn) ReadJSON(v any) error {
+	_, p, err := w.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+func (w *WSConn) WriteMessage(messageType int, data []byte) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.writeWait))
+	return w.conn.WriteMessage(messageType, data)
+}
+
+func (w *WSConn) WriteJSON(v any) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	_ = w.conn.SetWriteDeadline(time.Now().Add(w.writeWait))
+	return w.conn.WriteJSON(v)
+}
+
+// Done 连接关闭后 close
+func (w *WSConn) Done() <-chan struct{} {
+	return w.done
+}
+
+// Conn 底层连接，写操作需使用 WSConn 的方法保证并发安全
+func (w *WSConn) Conn() *websocket.Conn {
+	return w.conn
+}
+
+// CloseWith 发送 close frame 后关闭连接
+func (w *WSConn) CloseWith(code int, text string) error {
+	err := w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(w.writeWait))
+	w.Close()
+	return err
+}
+
+func (w *WSConn) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		_ = w.conn.Close()
+	})
+}
+
+func (w *WSConn) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			// WriteControl 可以与其他写方法并发调用
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(w.writeWait)); err != nil {
+				w.Close()
+				return
+			}
+		}
+	}
+}
+
+// ============================================================================================================
+
+type wsHub struct {
+	mu       sync.Mutex
+	conns    map[*WSConn]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+	total    int64
+	rejected int64
+}
+
+func newWsHub() *wsHub {
+	return &wsHub{conns: make(map[*WSConn]struct{})}
+}
+
+func (h *wsHub) add(w *WSConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		atomic.AddInt64(&h.rejected, 1)
+		return false
+	}
+	h.conns[w] = struct{}{}
+	h.wg.Add(1)
+	h.total++
+	return true
+}
+
+func (h *wsHub) remove(w *WSConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[w]; ok {
+		delete(h.conns, w)
+		h.wg.Done()
+	}
+}
+
+func (h *wsHub) isClosing() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closing
+}
+
+func (h *wsHub) stats() WebSocketStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return WebSocketStats{
+		Active:   int64(len(h.conns)),
+		Total:    h.total,
+		Rejected: atomic.LoadInt64(&h.rejected),
+	}
+}
+
+// shutdown 向所有连接发送 close frame，等待 handler 退出，ctx 超时后强制关闭
+func (h *wsHub) shutdown(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.closing = true
+	conns := make([]*WSConn, 0, len(h.conns))
+	for w := range h.conns {
+		conns = append(conns, w)
+	}
+	h.mu.Unlock()
+	if len(conns) == 0 {
+		return
+	}
+	xlog.Warnf("closing %d websocket connections", len(conns))
+	for _, w := range conns {
+		_ = w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(w.writeWait))
+	}
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		for _, w := range conns {
+			w.Close()
+		}
+	}
+}
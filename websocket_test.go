@@ -0,0 +1,81 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pay/web/middleware"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocket(t *testing.T) {
+	g := InitGin(&Config{Addr: ":0", CORS: &middleware.CORSConfig{AllowOrigins: []string{"*.gopay.ink"}}})
+	g.timeout = 2 * time.Second
+	g.Gin.GET("/ws", middleware.AccessLog("test"), g.WebSocket(nil, func(c *gin.Context, conn *WSConn) {
+		for {
+			mt, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}))
+	srv := httptest.NewServer(g.Gin)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.com"}})
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() disallowed origin err = %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://m.gopay.ink"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, p, err := conn.ReadMessage(); err != nil || string(p) != "hello" {
+		t.Fatalf("ReadMessage() = %s, %v", p, err)
+	}
+	if st := g.WebSocketStats(); st.Active != 1 || st.Rejected != 1 {
+		t.Fatalf("WebSocketStats() = %+v", st)
+	}
+
+	go func() {
+		// 收到 close frame 后 gorilla 客户端自动回复 close
+		_, _, _ = conn.ReadMessage()
+	}()
+	g.Close()
+	if st := g.WebSocketStats(); st.Active != 0 {
+		t.Fatalf("WebSocketStats() after Close = %+v", st)
+	}
+}
+
+func TestWebSocketSameOrigin(t *testing.T) {
+	g := InitGin(&Config{Addr: ":0"})
+	g.Gin.GET("/ws", g.WebSocket(nil, func(c *gin.Context, conn *WSConn) {}))
+	srv := httptest.NewServer(g.Gin)
+	defer srv.Close()
+	defer g.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	// 未配置 CORS 时拒绝跨站 Origin
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.com"}})
+	if err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() foreign origin err = %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatalf("Dial() same origin err = %v", err)
+	}
+	_ = conn.Close()
+}